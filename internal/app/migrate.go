@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"template/internal/db"
+	"template/internal/logistic"
+	"template/internal/migrate"
+	"template/internal/office"
+)
+
+type migrateTarget struct {
+	service string
+	dsn     string
+	fsys    fs.FS
+}
+
+// runMigrate — SERVICE=migrate: миграции БД office и logistic без запуска сервисов.
+// Команда в аргументах: up (по умолчанию), down [N] (по умолчанию 1), status;
+// MIGRATE_SERVICE — office или logistic (пусто — обе, кроме down)
+func runMigrate(args []string) {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	targets := []migrateTarget{
+		{"office", getenv("OFFICE_DSN", defaultOfficeDSN), office.Migrations()},
+		{"logistic", getenv("LOGISTIC_DSN", defaultLogisticDSN), logistic.Migrations()},
+	}
+	if only := os.Getenv("MIGRATE_SERVICE"); only != "" {
+		var picked []migrateTarget
+		for _, t := range targets {
+			if t.service == only {
+				picked = append(picked, t)
+			}
+		}
+		if len(picked) == 0 {
+			log.Fatalf("migrate: unknown MIGRATE_SERVICE %q", only)
+		}
+		targets = picked
+	}
+
+	ctx := context.Background()
+	switch cmd {
+	case "up":
+		for _, t := range targets {
+			done, err := migrator(t).Up(ctx)
+			if err != nil {
+				log.Fatalf("migrate %s: %v", t.service, err)
+			}
+			log.Printf("[migrate] %s: %d applied", t.service, len(done))
+		}
+	case "down":
+		if len(targets) != 1 {
+			log.Fatalf("migrate down: set MIGRATE_SERVICE")
+		}
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("migrate down: bad step count %q", args[1])
+			}
+			steps = n
+		}
+		if _, err := migrator(targets[0]).Down(ctx, steps); err != nil {
+			log.Fatalf("migrate %s: %v", targets[0].service, err)
+		}
+	case "status":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tVERSION\tNAME\tAPPLIED")
+		for _, t := range targets {
+			states, err := migrator(t).Status(ctx)
+			if err != nil {
+				log.Fatalf("migrate %s: %v", t.service, err)
+			}
+			for _, s := range states {
+				applied := "pending"
+				if s.AppliedAt != nil {
+					applied = s.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+				}
+				name := s.Name
+				if s.Unknown {
+					name = "(unknown to this build)"
+				}
+				fmt.Fprintf(w, "%s\t%04d\t%s\t%s\n", t.service, s.Version, name, applied)
+			}
+		}
+		w.Flush()
+	default:
+		log.Fatalf("migrate: unknown command %q (up, down [N], status)", cmd)
+	}
+}
+
+func migrator(t migrateTarget) *migrate.Migrator {
+	m, err := migrate.New(db.MustConnect(t.dsn), t.service, t.fsys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return m
+}
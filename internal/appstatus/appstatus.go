@@ -0,0 +1,94 @@
+// Package appstatus — статусы заявки и допустимые переходы между ними,
+// общие для office и logistic.
+//
+//	NEW → IN_PROGRESS → SHIPPED → DELIVERED
+//	NEW, IN_PROGRESS → CANCELLED (после отгрузки отменить нельзя)
+//
+// DELIVERED и CANCELLED — конечные. Переход в тот же статус — не ошибка, а
+// пустая операция: так повторная доставка колбэка logistic → office безопасна.
+package appstatus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type Status string
+
+const (
+	New        Status = "NEW"
+	InProgress Status = "IN_PROGRESS"
+	Shipped    Status = "SHIPPED"
+	Delivered  Status = "DELIVERED"
+	Cancelled  Status = "CANCELLED"
+)
+
+// MaxReasonLen — предел длины причины смены статуса (в символах)
+const MaxReasonLen = 500
+
+var (
+	ErrUnknownStatus = errors.New("unknown application status")
+	ErrReasonTooLong = fmt.Errorf("reason longer than %d characters", MaxReasonLen)
+	// ErrConflict — статус успели изменить между чтением и записью
+	ErrConflict = errors.New("application status changed concurrently")
+)
+
+var transitions = map[Status][]Status{
+	New:        {InProgress, Cancelled},
+	InProgress: {Shipped, Cancelled},
+	Shipped:    {Delivered},
+	Delivered:  nil,
+	Cancelled:  nil,
+}
+
+// TransitionError — переход запрещён; Allowed — куда можно из From
+type TransitionError struct {
+	From, To Status
+	Allowed  []Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("status transition %s -> %s is not allowed", e.From, e.To)
+}
+
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// Terminal — из статуса переходов нет
+func (s Status) Terminal() bool { return s.Valid() && len(transitions[s]) == 0 }
+
+// Next — статусы, в которые можно перейти из s
+func (s Status) Next() []Status { return append([]Status(nil), transitions[s]...) }
+
+// Check — можно ли перевести заявку из from в to: ErrUnknownStatus для
+// неизвестного to, *TransitionError для запрещённого перехода
+func Check(from, to Status) error {
+	if !to.Valid() {
+		return fmt.Errorf("%w %q", ErrUnknownStatus, to)
+	}
+	if from == to {
+		return nil
+	}
+	for _, s := range transitions[from] {
+		if s == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to, Allowed: from.Next()}
+}
+
+// Reason — причина для истории: без пробелов по краям, пустая — nil
+func Reason(s string) (*string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if utf8.RuneCountInString(s) > MaxReasonLen {
+		return nil, ErrReasonTooLong
+	}
+	return &s, nil
+}
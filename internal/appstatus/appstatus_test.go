@@ -0,0 +1,107 @@
+package appstatus
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var all = []Status{New, InProgress, Shipped, Delivered, Cancelled}
+
+func TestCheck(t *testing.T) {
+	allowed := map[[2]Status]bool{
+		{New, InProgress}:       true,
+		{New, Cancelled}:        true,
+		{InProgress, Shipped}:   true,
+		{InProgress, Cancelled}: true,
+		{Shipped, Delivered}:    true,
+	}
+	for _, from := range all {
+		for _, to := range all {
+			err := Check(from, to)
+			switch {
+			case from == to:
+				if err != nil {
+					t.Errorf("%s -> %s: same status must be a no-op, got %v", from, to, err)
+				}
+			case allowed[[2]Status{from, to}]:
+				if err != nil {
+					t.Errorf("%s -> %s: want allowed, got %v", from, to, err)
+				}
+			default:
+				var te *TransitionError
+				if !errors.As(err, &te) {
+					t.Errorf("%s -> %s: want *TransitionError, got %v", from, to, err)
+					continue
+				}
+				if te.From != from || te.To != to || !slices.Equal(te.Allowed, from.Next()) {
+					t.Errorf("%s -> %s: bad error %+v", from, to, te)
+				}
+			}
+		}
+	}
+}
+
+func TestCheckRejectsCancelAfterShipment(t *testing.T) {
+	for _, from := range []Status{Shipped, Delivered} {
+		var te *TransitionError
+		if err := Check(from, Cancelled); !errors.As(err, &te) {
+			t.Errorf("%s -> CANCELLED: want *TransitionError, got %v", from, err)
+		}
+	}
+}
+
+func TestCheckUnknownStatus(t *testing.T) {
+	for _, to := range []Status{"", "new", "LOST"} {
+		if err := Check(New, to); !errors.Is(err, ErrUnknownStatus) {
+			t.Errorf("New -> %q: want ErrUnknownStatus, got %v", to, err)
+		}
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	for _, s := range all {
+		want := s == Delivered || s == Cancelled
+		if s.Terminal() != want {
+			t.Errorf("%s.Terminal() = %v, want %v", s, s.Terminal(), want)
+		}
+	}
+	if Status("LOST").Terminal() {
+		t.Error("unknown status must not be terminal")
+	}
+}
+
+func TestNextReturnsCopy(t *testing.T) {
+	n := New.Next()
+	n[0] = Delivered
+	if New.Next()[0] != InProgress {
+		t.Fatal("Next exposes the transition table")
+	}
+}
+
+func TestReason(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *string
+		err  error
+	}{
+		{in: "", want: nil},
+		{in: "   \t", want: nil},
+		{in: "  truck broke down ", want: ptr("truck broke down")},
+		{in: strings.Repeat("я", MaxReasonLen), want: ptr(strings.Repeat("я", MaxReasonLen))},
+		{in: strings.Repeat("я", MaxReasonLen+1), err: ErrReasonTooLong},
+	}
+	for _, tt := range tests {
+		got, err := Reason(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Reason(%.20q): err %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("Reason(%.20q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func ptr(s string) *string { return &s }
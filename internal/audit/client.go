@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Client — отправка событий в сервис audit из других сервисов.
+// http-клиент должен подставлять токен с разрешением audit:write (см. svcauth).
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewClient(baseURL string, hc *http.Client) *Client {
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), http: hc}
+}
+
+func (c *Client) Send(ctx context.Context, e Event) error {
+	if c == nil || c.baseURL == "" {
+		return nil
+	}
+	body, _ := json.Marshal(e)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/audit/events", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("audit: %s", resp.Status)
+	}
+	return nil
+}
@@ -7,16 +7,23 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+
+	"template/internal/middleware/authmw"
 )
 
-type Handler struct{ repo Repo }
+type Handler struct {
+	repo Repo
+	mw   *authmw.MW
+}
 
-func NewHandler(repo Repo) *Handler { return &Handler{repo: repo} }
+func NewHandler(repo Repo, mw *authmw.MW) *Handler { return &Handler{repo: repo, mw: mw} }
 
 func (h *Handler) Register(r *mux.Router) {
 	// r — суброутер с префиксом /audit
-	r.HandleFunc("/events", h.create).Methods("POST")
-	r.HandleFunc("/events", h.list).Methods("GET")
+	can := func(perm string, f http.HandlerFunc) http.Handler { return h.mw.RequirePermission(perm)(f) }
+
+	r.Handle("/events", can(authmw.PermAuditWrite, h.create)).Methods("POST")
+	r.Handle("/events", can(authmw.PermAuditRead, h.list)).Methods("GET")
 	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 }
 
@@ -36,6 +43,7 @@ func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
 		TargetService: r.URL.Query().Get("target"),
 		URI:           r.URL.Query().Get("uri"),
 		UserID:        r.URL.Query().Get("user"),
+		Action:        r.URL.Query().Get("action"),
 		SortBy:        r.URL.Query().Get("sort"),
 		SortOrder:     r.URL.Query().Get("order"),
 	}
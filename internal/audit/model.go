@@ -11,6 +11,7 @@ type Event struct {
 	At            time.Time `json:"at"` // дата события
 	DurationMs    int64     `json:"duration_ms"`
 	UserID        string    `json:"user_id,omitempty"`
+	Action        string    `json:"action,omitempty"` // прикладное событие, напр. "auth.lockout"
 	RequestBody   string    `json:"request_body,omitempty"`
 	ResponseBody  string    `json:"response_body,omitempty"`
 }
@@ -24,6 +25,7 @@ type Query struct {
 	DateTo        *time.Time
 	MinDurationMs *int64
 	UserID        string
+	Action        string
 
 	SortBy    string // source|target|status|duration|user
 	SortOrder string // asc|desc
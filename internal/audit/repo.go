@@ -64,6 +64,9 @@ func (r *memRepo) List(q Query) ([]Event, int) {
 		if q.UserID != "" && !strings.EqualFold(it.UserID, q.UserID) {
 			continue
 		}
+		if q.Action != "" && it.Action != q.Action {
+			continue
+		}
 		flt = append(flt, it)
 	}
 
@@ -3,16 +3,23 @@ package audit
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+
+	"template/internal/middleware/authmw"
+	"template/internal/svcauth"
 )
 
-func Start(port string) {
+func Start(port, jwksURL, revocationsURL, tokenURL, clientID, clientSecret string) {
 	repo := NewMemRepo()
 
 	r := mux.NewRouter()
 	auditRouter := r.PathPrefix("/audit").Subrouter()
-	NewHandler(repo).Register(auditRouter)
+	// список отзыва — из внутреннего API auth с токеном сервиса audit
+	authClient := svcauth.NewTokenSource(tokenURL, clientID, clientSecret).Client()
+	mw := authmw.New(authmw.NewRemoteJWKS(jwksURL, 5*time.Minute), authmw.NewRemoteRevocations(revocationsURL, authClient, 15*time.Second))
+	NewHandler(repo, mw).Register(auditRouter)
 
 	log.Printf("[audit] :%s (in-memory)", port)
 	log.Fatal(http.ListenAndServe(":"+port, r))
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"template/internal/middleware/authmw"
+)
+
+var (
+	ErrOrgNotFound       = errors.New("organization not found")
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrAPIKeyPermissions = errors.New("permissions not allowed for api keys")
+)
+
+// apiKeyDenied — разрешения, которые ключу интеграции не выдаются
+var apiKeyDenied = []string{authmw.PermUsersManage, authmw.PermAuditRead, authmw.PermAuditWrite}
+
+// apiKeyPrefix — по нему ключ узнаётся в логах и сканерах секретов
+const apiKeyPrefix = "a7_"
+
+type Organization struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+// APIKey — долгоживущий ключ интеграции организации; в хранилище только
+// SHA-256 от ключа, сам ключ показывается один раз при создании
+type APIKey struct {
+	ID             int64
+	OrganizationID int64
+	Name           string
+	Prefix         string // первые символы ключа, для отображения
+	Hash           string
+	Permissions    []string
+	RateLimit      int // запросов в минуту, 0 — без лимита
+	CreatedBy      int64
+	CreatedAt      time.Time
+	ExpiresAt      *time.Time
+	LastUsedAt     *time.Time
+	RevokedAt      *time.Time
+}
+
+func (k APIKey) active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// NewAPIKey — параметры нового ключа
+type NewAPIKey struct {
+	OrganizationID int64
+	Name           string
+	Permissions    []string
+	RateLimit      int
+	ExpiresAt      *time.Time
+	CreatedBy      int64
+}
+
+func (s *service) CreateOrganization(name string) (Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Organization{}, ErrInvalidInput
+	}
+	return s.repo.CreateOrganization(name)
+}
+
+func (s *service) ListOrganizations() ([]Organization, error) {
+	return s.repo.ListOrganizations()
+}
+
+// CreateAPIKey — ключ возвращается вторым значением и больше нигде не хранится
+func (s *service) CreateAPIKey(in NewAPIKey, client ClientInfo) (APIKey, string, error) {
+	if _, ok := s.repo.OrganizationByID(in.OrganizationID); !ok {
+		return APIKey{}, "", ErrOrgNotFound
+	}
+	if strings.TrimSpace(in.Name) == "" || len(in.Permissions) == 0 || in.RateLimit < 0 {
+		return APIKey{}, "", ErrInvalidInput
+	}
+	for _, p := range in.Permissions {
+		if !slices.Contains(authmw.Permissions, p) || slices.Contains(apiKeyDenied, p) {
+			return APIKey{}, "", ErrAPIKeyPermissions
+		}
+	}
+	secret := apiKeyPrefix + randomToken(32)
+	k, err := s.repo.CreateAPIKey(APIKey{
+		OrganizationID: in.OrganizationID,
+		Name:           strings.TrimSpace(in.Name),
+		Prefix:         secret[:len(apiKeyPrefix)+8],
+		Hash:           hashToken(secret),
+		Permissions:    in.Permissions,
+		RateLimit:      in.RateLimit,
+		ExpiresAt:      in.ExpiresAt,
+		CreatedBy:      in.CreatedBy,
+	})
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	s.emit(client, "auth.api_key_created", k.Prefix, http.StatusCreated, "org="+formatID(&k.OrganizationID))
+	return k, secret, nil
+}
+
+func (s *service) ListAPIKeys(orgID int64) ([]APIKey, error) {
+	if _, ok := s.repo.OrganizationByID(orgID); !ok {
+		return nil, ErrOrgNotFound
+	}
+	return s.repo.ListAPIKeys(orgID)
+}
+
+// RevokeAPIKey — отзыв ключа; сервисы, закешировавшие его, узнают из списка отзыва
+func (s *service) RevokeAPIKey(id int64, client ClientInfo) error {
+	k, err := s.repo.RevokeAPIKey(id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.AddRevocations([]RevokedToken{{
+		Kind:      revokedJTI,
+		Value:     authmw.APIKeyJTI(k.ID),
+		ExpiresAt: time.Now().Add(2 * authmw.APIKeyCacheTTL),
+	}}); err != nil {
+		return err
+	}
+	s.emit(client, "auth.api_key_revoked", k.Prefix, http.StatusOK, "org="+formatID(&k.OrganizationID))
+	return nil
+}
+
+// IntrospectAPIKey — проверка ключа для authmw; отмечает время использования
+func (s *service) IntrospectAPIKey(key string) (authmw.APIKeyInfo, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return authmw.APIKeyInfo{}, nil
+	}
+	k, ok := s.repo.APIKeyByHash(hashToken(key))
+	if !ok || !k.active(time.Now()) {
+		return authmw.APIKeyInfo{}, nil
+	}
+	if err := s.repo.TouchAPIKey(k.ID); err != nil {
+		return authmw.APIKeyInfo{}, err
+	}
+	return authmw.APIKeyInfo{
+		Active:         true,
+		ID:             k.ID,
+		Prefix:         k.Prefix,
+		OrganizationID: k.OrganizationID,
+		Permissions:    k.Permissions,
+		RateLimit:      k.RateLimit,
+	}, nil
+}
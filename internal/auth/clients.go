@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"template/internal/middleware/authmw"
+)
+
+var ErrInvalidClient = errors.New("invalid client")
+
+// ServiceClients — машинные учётки сервисов (client credentials), из конфигурации
+type ServiceClients map[string][32]byte // client_id → sha256(secret)
+
+// ParseServiceClients разбирает строку вида "logistic:secret1,office:secret2"
+func ParseServiceClients(spec string) (ServiceClients, error) {
+	out := ServiceClients{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(part, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, errors.New("service clients: expected id:secret")
+		}
+		out[id] = sha256.Sum256([]byte(secret))
+	}
+	return out, nil
+}
+
+func (c ServiceClients) check(id, secret string) bool {
+	want, ok := c[id]
+	got := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(want[:], got[:]) == 1 && ok
+}
+
+// ClientToken — токен сервиса по client credentials
+func (s *service) ClientToken(clientID, clientSecret string) (string, time.Duration, error) {
+	if !s.clients.check(clientID, clientSecret) {
+		return "", 0, ErrInvalidClient
+	}
+	tok, err := signServiceToken(s.keys, s.repo, clientID, s.ttl)
+	return tok, s.ttl, err
+}
+
+func signServiceToken(keys *KeySet, repo Repo, clientID string, ttl time.Duration) (string, error) {
+	perms, err := repo.RolePermissions(authmw.RoleService)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return keys.Sign(jwt.MapClaims{
+		"sub":   clientID,
+		"role":  authmw.RoleService,
+		"perms": perms,
+		"jti":   newTokenID(),
+		"iat":   now.Unix(),
+		"exp":   now.Add(ttl).Unix(),
+		"iss":   authmw.Issuer,
+		"aud":   authmw.Audience,
+	})
+}
+
+// selfToken — токен сервиса для собственных вызовов auth (например, в audit):
+// auth сам выпускает токены, ходить за ним по сети незачем
+type selfToken struct {
+	keys *KeySet
+	repo Repo
+}
+
+func (t selfToken) Token(context.Context) (string, error) {
+	return signServiceToken(t.keys, t.repo, "auth", 5*time.Minute)
+}
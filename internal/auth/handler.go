@@ -2,33 +2,105 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+
+	"template/internal/middleware/authmw"
 )
 
-type Handler struct{ svc Service }
+type Handler struct {
+	svc  Service
+	mw   *authmw.MW
+	keys *KeySet
+}
 
-func NewHandler(svc Service) *Handler { return &Handler{svc: svc} }
+func NewHandler(svc Service, mw *authmw.MW, keys *KeySet) *Handler {
+	return &Handler{svc: svc, mw: mw, keys: keys}
+}
 
 func (h *Handler) Register(r *mux.Router) {
 	r.HandleFunc("/login", h.login).Methods("POST")
+	r.HandleFunc("/login/2fa", h.loginMFA).Methods("POST")
+	r.HandleFunc("/2fa/enroll", h.enrollTOTP).Methods("POST")
+	r.HandleFunc("/2fa/confirm", h.confirmTOTP).Methods("POST")
+	r.Handle("/2fa/disable", h.mw.RequireToken(http.HandlerFunc(h.disableTOTP))).Methods("POST")
+	r.Handle("/2fa/recovery-codes", h.mw.RequireToken(http.HandlerFunc(h.recoveryCodes))).Methods("POST")
+	r.HandleFunc("/register", h.register).Methods("POST")
+	r.HandleFunc("/refresh", h.refresh).Methods("POST")
+	r.HandleFunc("/logout", h.logout).Methods("POST")
+	r.HandleFunc("/token", h.token).Methods("POST")
+	r.HandleFunc("/password/change", h.changePassword).Methods("POST")
+	r.HandleFunc("/password/forgot", h.forgotPassword).Methods("POST")
+	r.HandleFunc("/password/reset", h.resetPasswordByToken).Methods("POST")
+	h.registerUsers(r)
+	h.registerAPIKeys(r)
+	h.registerSessions(r)
+	h.registerOIDC(r)
 	r.HandleFunc("/verify", h.verify).Methods("GET")
+	r.HandleFunc("/validate", h.verify).Methods("GET") // имя, которое ждёт Middleware/auth
+	r.HandleFunc("/.well-known/jwks.json", h.jwks).Methods("GET")
+	r.HandleFunc("/revoke", h.revoke).Methods("POST")
 	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 }
 
+// RegisterInternal — вызовы других сервисов с их токенами: проверка ключей
+// интеграций (office) и список отзыва для authmw
+func (h *Handler) RegisterInternal(r *mux.Router) {
+	r.Handle("/api-keys/introspect", h.mw.RequireService("office")(http.HandlerFunc(h.introspectAPIKey))).Methods("POST")
+	r.Handle("/revocations", h.mw.RequireService("office", "logistic", "audit")(http.HandlerFunc(h.revocations))).Methods("GET")
+}
+
 type loginReq struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
 type loginResp struct {
-	AccessToken string        `json:"access_token"`
-	TokenType   string        `json:"token_type"`
-	ExpiresIn   time.Duration `json:"expires_in"`
-	Role        string        `json:"role"`
+	AccessToken  string        `json:"access_token"`
+	RefreshToken string        `json:"refresh_token"`
+	TokenType    string        `json:"token_type"`
+	ExpiresIn    time.Duration `json:"expires_in"`
+	Role         string        `json:"role"`
+}
+
+func newLoginResp(p TokenPair) loginResp {
+	return loginResp{
+		AccessToken:  p.AccessToken,
+		RefreshToken: p.RefreshToken,
+		TokenType:    "bearer",
+		ExpiresIn:    p.ExpiresIn,
+		Role:         p.Role,
+	}
+}
+
+// challengeResp — вместо токенов, когда нужен второй фактор
+type challengeResp struct {
+	MFARequired            bool          `json:"mfa_required"`
+	EnrollmentRequired     bool          `json:"enrollment_required,omitempty"`
+	PasswordChangeRequired bool          `json:"password_change_required,omitempty"`
+	ChallengeToken         string        `json:"challenge_token"`
+	ExpiresIn              time.Duration `json:"expires_in"`
+}
+
+func writeLoginResult(w http.ResponseWriter, code int, res LoginResult) {
+	if c := res.Challenge; c != nil {
+		respondJSON(w, code, challengeResp{
+			MFARequired:            !c.PasswordChange,
+			EnrollmentRequired:     c.Enroll,
+			PasswordChangeRequired: c.PasswordChange,
+			ChallengeToken:         c.Token,
+			ExpiresIn:              c.ExpiresIn,
+		})
+		return
+	}
+	respondJSON(w, code, newLoginResp(res.Tokens))
 }
 
 func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
@@ -37,27 +109,538 @@ func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	// страховка от «мусора»
+	// страховка от «мусора»; пароль не трогаем — пробелы в нём значимы
 	req.Username = strings.TrimSpace(req.Username)
-	req.Password = strings.TrimSpace(req.Password)
 
-	token, role, ttl, err := h.svc.Login(req.Username, req.Password)
+	res, err := h.svc.Login(req.Username, req.Password, clientInfo(r))
+	if err != nil {
+		writeLoginError(w, err)
+		return
+	}
+	writeLoginResult(w, http.StatusOK, res)
+}
+
+type mfaReq struct {
+	ChallengeToken string `json:"challenge_token,omitempty"`
+	Code           string `json:"code"` // код TOTP или резервный код
+}
+
+func (h *Handler) loginMFA(w http.ResponseWriter, r *http.Request) {
+	var req mfaReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	pair, err := h.svc.LoginMFA(req.ChallengeToken, req.Code, clientInfo(r))
+	if err != nil {
+		writeLoginError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, newLoginResp(pair))
+}
+
+type changePasswordReq struct {
+	ChallengeToken  string `json:"challenge_token,omitempty"` // обязательная смена при входе
+	CurrentPassword string `json:"current_password,omitempty"`
+	NewPassword     string `json:"new_password"`
+}
+
+// changePassword — по challenge-токену входа (ответ как у /login) либо
+// по access-токену с текущим паролем (остальные сессии отзываются)
+func (h *Handler) changePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if req.ChallengeToken != "" {
+		res, err := h.svc.ChangeExpiredPassword(req.ChallengeToken, req.NewPassword, clientInfo(r))
+		if err != nil {
+			writePasswordError(w, err)
+			return
+		}
+		writeLoginResult(w, http.StatusOK, res)
+		return
+	}
+	tok := authmw.Bearer(r)
+	if tok == "" {
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+	c, err := h.mw.Parse(tok)
 	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if c.Role == authmw.RoleService {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := h.svc.ChangePassword(c.UserID, req.CurrentPassword, req.NewPassword, c.SessionID, clientInfo(r)); err != nil {
+		writePasswordError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type forgotPasswordReq struct {
+	Login string `json:"login"` // логин или email
+}
+
+// forgotPassword — всегда 202, чтобы не выдавать, есть ли такой пользователь
+func (h *Handler) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.ForgotPassword(req.Login, clientInfo(r)); err != nil {
+		if errors.Is(err, ErrResetDisabled) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		writeLoginError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type resetPasswordByTokenReq struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+func (h *Handler) resetPasswordByToken(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordByTokenReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.ResetPasswordByToken(req.Token, req.NewPassword, clientInfo(r)); err != nil {
+		if errors.Is(err, ErrInvalidResetToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writePasswordError(w http.ResponseWriter, err error) {
+	var te *ThrottleError
+	if errors.As(err, &te) {
+		writeLoginError(w, err)
+		return
+	}
+	if errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrInvalidChallenge) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	writeUserError(w, err)
+}
+
+type enrollResp struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// enrollTOTP — по access-токену либо по enroll-challenge, если TOTP обязателен
+func (h *Handler) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	uid, _, ok := h.mfaUser(w, r)
+	if !ok {
+		return
+	}
+	e, err := h.svc.EnrollTOTP(uid)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, enrollResp{Secret: e.Secret, OTPAuthURI: e.URI})
+}
+
+type confirmResp struct {
+	RecoveryCodes []string   `json:"recovery_codes"`
+	Tokens        *loginResp `json:"tokens,omitempty"` // при подтверждении по enroll-challenge
+}
+
+func (h *Handler) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	uid, challenge, ok := h.mfaUser(w, r)
+	if !ok {
+		return
+	}
+	var req mfaReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	var resp confirmResp
+	if challenge != "" {
+		codes, pair, err := h.svc.ConfirmEnrollment(challenge, req.Code, clientInfo(r))
+		if err != nil {
+			writeMFAError(w, err)
+			return
+		}
+		lr := newLoginResp(pair)
+		resp = confirmResp{RecoveryCodes: codes, Tokens: &lr}
+	} else {
+		codes, err := h.svc.ConfirmTOTP(uid, req.Code, clientInfo(r))
+		if err != nil {
+			writeMFAError(w, err)
+			return
+		}
+		resp = confirmResp{RecoveryCodes: codes}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req mfaReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	p, _ := authmw.FromContext(r.Context())
+	if err := h.svc.DisableTOTP(p.UserID, req.Code, clientInfo(r)); err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) recoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req mfaReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	p, _ := authmw.FromContext(r.Context())
+	codes, err := h.svc.RegenerateRecoveryCodes(p.UserID, req.Code, clientInfo(r))
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, confirmResp{RecoveryCodes: codes})
+}
+
+// mfaUser — чей TOTP настраиваем: владелец access-токена либо enroll-challenge
+// (тогда он возвращается вторым значением); при ошибке ответ уже записан
+func (h *Handler) mfaUser(w http.ResponseWriter, r *http.Request) (int64, string, bool) {
+	tok := authmw.Bearer(r)
+	if tok == "" {
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return 0, "", false
+	}
+	if uid, err := h.svc.EnrollmentUser(tok); err == nil {
+		return uid, tok, true
+	}
+	c, err := h.mw.Parse(tok)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return 0, "", false
+	}
+	if c.Role == authmw.RoleService {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return 0, "", false
+	}
+	return c.UserID, "", true
+}
+
+func writeMFAError(w http.ResponseWriter, err error) {
+	var te *ThrottleError
+	switch {
+	case errors.As(err, &te):
+		writeLoginError(w, err)
+	case errors.Is(err, ErrInvalidOTP), errors.Is(err, ErrInvalidChallenge):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, ErrMFAAlreadyEnabled), errors.Is(err, ErrMFANotEnrolled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrMFAMandatory):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "server error", http.StatusInternalServerError)
+	}
+}
+
+// writeLoginError — 429/423 с Retry-After при защите от перебора, иначе 401
+func writeLoginError(w http.ResponseWriter, err error) {
+	var te *ThrottleError
+	if errors.As(err, &te) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(te.RetryAfter.Seconds()))))
+		code := http.StatusTooManyRequests
+		if errors.Is(err, ErrAccountLocked) {
+			code = http.StatusLocked
+		}
+		http.Error(w, te.Err.Error(), code)
+		return
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
-	respondJSON(w, http.StatusOK, loginResp{
-		AccessToken: token,
-		TokenType:   "bearer",
-		ExpiresIn:   ttl,
-		Role:        role,
-	})
+	if errors.Is(err, ErrInvalidOTP) || errors.Is(err, ErrInvalidChallenge) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, ErrAccountDisabled) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, "server error", http.StatusInternalServerError)
 }
 
-func (h *Handler) verify(w http.ResponseWriter, r *http.Request) {
+// clientIP — адрес клиента; за нашим прокси — последний элемент X-Forwarded-For
+// (его дописывает прокси, остальное мог прислать сам клиент)
+func clientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		parts := strings.Split(xff, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func clientInfo(r *http.Request) ClientInfo {
+	return ClientInfo{IP: clientIP(r), UserAgent: r.UserAgent(), URI: r.URL.Path}
+}
+
+func (h *Handler) unlock(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Unlock(userID(r), clientInfo(r)); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type registerReq struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role,omitempty"` // только с разрешением users:manage
+}
+
+// register — самостоятельная регистрация без токена либо регистрация администратором
+func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
+	var req registerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+
+	var (
+		res LoginResult
+		err error
+	)
+	if tok := authmw.Bearer(r); tok != "" {
+		c, perr := h.mw.Parse(tok)
+		if perr != nil {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		if !c.Has(authmw.PermUsersManage) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if req.Role == "" {
+			http.Error(w, "role required", http.StatusBadRequest)
+			return
+		}
+		res, err = h.svc.Register(req.Username, req.Password, req.Role, clientInfo(r))
+	} else {
+		if req.Role != "" {
+			http.Error(w, "role can be set by admin only", http.StatusForbidden)
+			return
+		}
+		res, err = h.svc.SelfRegister(req.Username, req.Password, clientInfo(r))
+	}
+	switch {
+	case errors.Is(err, ErrRegistrationDisabled):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, ErrUsernameTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ErrWeakPassword), errors.Is(err, ErrUnknownRole), errors.Is(err, ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	writeLoginResult(w, http.StatusCreated, res)
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (h *Handler) refresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	pair, err := h.svc.Refresh(req.RefreshToken, clientInfo(r))
+	switch {
+	case errors.Is(err, ErrInvalidRefresh), errors.Is(err, ErrRefreshReuse):
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, newLoginResp(pair))
+}
+
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	var req refreshReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.Logout(req.RefreshToken); err != nil && !errors.Is(err, ErrInvalidRefresh) {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+type verifyResp struct {
+	Sub         string   `json:"sub"`
+	UserID      int64    `json:"user_id"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// verify — проверка токена для forward-auth: 200 с личностью либо 401
+func (h *Handler) verify(w http.ResponseWriter, r *http.Request) {
+	tok := authmw.Bearer(r)
+	if tok == "" {
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+	c, err := h.mw.Parse(tok)
+	if errors.Is(err, authmw.ErrTokenRevoked) {
+		http.Error(w, "token revoked", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if c.Role != authmw.RoleService { // у токенов сервисов нет сессии
+		active, err := h.svc.SessionActive(c.SessionID)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			http.Error(w, "token revoked", http.StatusUnauthorized)
+			return
+		}
+	}
+	respondJSON(w, http.StatusOK, verifyResp{Sub: c.Sub, UserID: c.UserID, Role: c.Role, Permissions: c.Permissions})
+}
+
+type tokenResp struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // секунды, как в OAuth 2.0
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// token — OAuth 2.0: client credentials для межсервисных вызовов
+// (учётка в форме client_id/client_secret или в Basic), а также
+// authorization_code и refresh_token для клиентов OIDC
+func (h *Handler) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+	case "authorization_code":
+		h.exchangeCode(w, r)
+		return
+	case "refresh_token":
+		h.refreshGrant(w, r)
+		return
+	default:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	tok, ttl, err := h.svc.ClientToken(id, secret)
+	if errors.Is(err, ErrInvalidClient) {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, tokenResp{AccessToken: tok, TokenType: "bearer", ExpiresIn: int64(ttl.Seconds())})
+}
+
+func (h *Handler) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondJSON(w, http.StatusOK, h.keys.JWKS())
+}
+
+// revocations — список отзыва для authmw других сервисов; поддерживает If-None-Match
+func (h *Handler) revocations(w http.ResponseWriter, r *http.Request) {
+	l, err := h.svc.RevocationList()
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	etag := l.ETag()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	respondJSON(w, http.StatusOK, l)
+}
+
+// revoke — отзыв access-токена его владельцем (как RFC 7009: token в форме);
+// недействительный или уже отозванный токен — тоже 200
+func (h *Handler) revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	c, err := h.mw.ParseAnyAudience(r.PostForm.Get("token"))
+	if err != nil || c.ExpiresAt == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := h.svc.RevokeAccess(c.ID, c.ExpiresAt.Time); err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func respondJSON(w http.ResponseWriter, code int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"template/internal/middleware/authmw"
+)
+
+// registerAPIKeys — организации и ключи интеграций (users:manage);
+// проверка ключа — во внутреннем API (RegisterInternal)
+func (h *Handler) registerAPIKeys(r *mux.Router) {
+	admin := func(f http.HandlerFunc) http.Handler {
+		return h.mw.RequirePermission(authmw.PermUsersManage)(f)
+	}
+	r.Handle("/orgs", admin(h.listOrgs)).Methods("GET")
+	r.Handle("/orgs", admin(h.createOrg)).Methods("POST")
+	r.Handle("/orgs/{id:[0-9]+}/api-keys", admin(h.listAPIKeys)).Methods("GET")
+	r.Handle("/orgs/{id:[0-9]+}/api-keys", admin(h.createAPIKey)).Methods("POST")
+	r.Handle("/api-keys/{id:[0-9]+}", admin(h.revokeAPIKey)).Methods("DELETE")
+}
+
+type orgResp struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (h *Handler) listOrgs(w http.ResponseWriter, r *http.Request) {
+	orgs, err := h.svc.ListOrganizations()
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]orgResp, 0, len(orgs))
+	for _, o := range orgs {
+		out = append(out, orgResp(o))
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) createOrg(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	o, err := h.svc.CreateOrganization(req.Name)
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, orgResp(o))
+}
+
+type apiKeyResp struct {
+	ID             int64      `json:"id"`
+	OrganizationID int64      `json:"organization_id"`
+	Name           string     `json:"name"`
+	Prefix         string     `json:"prefix"`
+	Key            string     `json:"key,omitempty"` // только в ответе на создание
+	Permissions    []string   `json:"permissions"`
+	RateLimit      int        `json:"rate_limit"`
+	CreatedBy      int64      `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+func newAPIKeyResp(k APIKey) apiKeyResp {
+	return apiKeyResp{
+		ID:             k.ID,
+		OrganizationID: k.OrganizationID,
+		Name:           k.Name,
+		Prefix:         k.Prefix,
+		Permissions:    k.Permissions,
+		RateLimit:      k.RateLimit,
+		CreatedBy:      k.CreatedBy,
+		CreatedAt:      k.CreatedAt,
+		ExpiresAt:      k.ExpiresAt,
+		LastUsedAt:     k.LastUsedAt,
+		RevokedAt:      k.RevokedAt,
+	}
+}
+
+// orgID — организация из пути запроса (маршруты только для администратора)
+func orgID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *Handler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	org, ok := orgID(w, r)
+	if !ok {
+		return
+	}
+	keys, err := h.svc.ListAPIKeys(org)
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+	out := make([]apiKeyResp, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, newAPIKeyResp(k))
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+type createAPIKeyReq struct {
+	Name        string     `json:"name"`
+	Permissions []string   `json:"permissions"`
+	RateLimit   int        `json:"rate_limit"` // в минуту, 0 — без лимита
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+func (h *Handler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	org, ok := orgID(w, r)
+	if !ok {
+		return
+	}
+	var req createAPIKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	k, secret, err := h.svc.CreateAPIKey(NewAPIKey{
+		OrganizationID: org,
+		Name:           req.Name,
+		Permissions:    req.Permissions,
+		RateLimit:      req.RateLimit,
+		ExpiresAt:      req.ExpiresAt,
+		CreatedBy:      actorID(r),
+	}, clientInfo(r))
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+	resp := newAPIKeyResp(k)
+	resp.Key = secret
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusCreated, resp)
+}
+
+func (h *Handler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err := h.svc.RevokeAPIKey(id, clientInfo(r)); err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// introspectAPIKey — {"key"} → authmw.APIKeyInfo; неизвестный ключ — {"active":false}
+func (h *Handler) introspectAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	info, err := h.svc.IntrospectAPIKey(req.Key)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, info)
+}
+
+func writeAPIKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrOrgNotFound), errors.Is(err, ErrAPIKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidInput), errors.Is(err, ErrAPIKeyPermissions):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,420 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+
+	"template/internal/middleware/authmw"
+)
+
+// registerOIDC — эндпойнты провайдера OpenID Connect; /token общий с client credentials
+func (h *Handler) registerOIDC(r *mux.Router) {
+	admin := func(f http.HandlerFunc) http.Handler {
+		return h.mw.RequirePermission(authmw.PermUsersManage)(f)
+	}
+	r.HandleFunc("/.well-known/openid-configuration", h.discovery).Methods("GET")
+	r.HandleFunc("/authorize", h.authorize).Methods("GET", "POST")
+	r.HandleFunc("/userinfo", h.userinfo).Methods("GET", "POST")
+	r.Handle("/oidc/clients", admin(h.listOIDCClients)).Methods("GET")
+	r.Handle("/oidc/clients", admin(h.createOIDCClient)).Methods("POST")
+	r.Handle("/oidc/clients/{id}", admin(h.deleteOIDCClient)).Methods("DELETE")
+}
+
+func (h *Handler) discovery(w http.ResponseWriter, r *http.Request) {
+	iss := h.svc.OIDCIssuer()
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                iss,
+		"authorization_endpoint":                iss + "/authorize",
+		"token_endpoint":                        iss + "/token",
+		"userinfo_endpoint":                     iss + "/userinfo",
+		"jwks_uri":                              iss + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwt.SigningMethodRS256.Alg()},
+		"scopes_supported":                      []string{scopeOpenID, scopeProfile, scopeEmail},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid", "role", "preferred_username", "email"},
+	})
+}
+
+func authorizeRequest(q url.Values) AuthorizeRequest {
+	return AuthorizeRequest{
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		Nonce:               q.Get("nonce"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+	}
+}
+
+// authorize — страница входа: GET показывает форму, POST (на тот же URL с
+// параметрами запроса) проверяет пароль, затем при необходимости код TOTP,
+// и перенаправляет на redirect_uri с code и state
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := authorizeRequest(q)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", authorizeCSP(""))
+
+	client, err := h.svc.CheckAuthorize(req)
+	if errors.Is(err, ErrOIDCClientNotFound) || errors.Is(err, ErrInvalidRedirectURI) {
+		// на непроверенный адрес не перенаправляем
+		renderLogin(w, http.StatusBadRequest, loginPage{Error: "Неизвестное приложение или адрес возврата", Fatal: true})
+		return
+	}
+	if err == nil && q.Get("response_type") != "code" {
+		err = oauthErr("unsupported_response_type", "only response_type=code is supported")
+	}
+	var oe *OAuthError
+	if errors.As(err, &oe) {
+		redirectAuthorize(w, r, req, url.Values{"error": {oe.Code}, "error_description": {oe.Description}})
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	// после POST форма перенаправляет на redirect_uri — его origin тоже разрешён
+	w.Header().Set("Content-Security-Policy", authorizeCSP(req.RedirectURI))
+	secret, fresh := h.csrfSecret(w, r)
+	page := loginPage{Client: client.Name, CSRF: csrfToken(secret, req)}
+	if r.Method == http.MethodGet {
+		renderLogin(w, http.StatusOK, page)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	if fresh || subtle.ConstantTimeCompare([]byte(r.PostForm.Get("csrf")), []byte(page.CSRF)) != 1 {
+		page.Error = "Страница входа устарела, попробуйте ещё раз"
+		renderLogin(w, http.StatusForbidden, page)
+		return
+	}
+
+	var code string
+	if challenge := r.PostForm.Get("challenge"); challenge != "" {
+		code, err = h.svc.AuthorizeMFA(req, challenge, r.PostForm.Get("otp"), clientInfo(r))
+		page.Challenge = challenge
+	} else {
+		var res AuthorizeResult
+		res, err = h.svc.AuthorizeLogin(req, strings.TrimSpace(r.PostForm.Get("username")), r.PostForm.Get("password"), clientInfo(r))
+		if err == nil && res.Challenge != nil {
+			page.Challenge = res.Challenge.Token
+			renderLogin(w, http.StatusOK, page)
+			return
+		}
+		code = res.Code
+	}
+	if err != nil {
+		page.Error = loginErrorText(err)
+		if errors.Is(err, ErrInvalidChallenge) {
+			page.Challenge = ""
+		}
+		renderLogin(w, http.StatusOK, page)
+		return
+	}
+	redirectAuthorize(w, r, req, url.Values{"code": {code}})
+}
+
+// authorizeCSP — политика страницы входа; redirectURI — уже проверенный адрес возврата
+func authorizeCSP(redirectURI string) string {
+	formAction := "'self'"
+	if u, err := url.Parse(redirectURI); err == nil && u.Scheme != "" && u.Host != "" {
+		formAction += " " + u.Scheme + "://" + u.Host
+	}
+	return "default-src 'none'; style-src 'unsafe-inline'; form-action " + formAction + "; frame-ancestors 'none'"
+}
+
+const csrfCookie = "a7_authorize_csrf"
+
+// csrfSecret — случайное значение в cookie браузера (fresh — только что выдано,
+// значит POST пришёл не с нашей формы)
+func (h *Handler) csrfSecret(w http.ResponseWriter, r *http.Request) (secret string, fresh bool) {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value, false
+	}
+	secret = randomToken(32)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    secret,
+		Path:     r.URL.Path,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.svc.OIDCIssuer(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return secret, true
+}
+
+// csrfToken — токен формы: HMAC секрета из cookie по параметрам запроса /authorize,
+// так что форма годится только для того запроса, для которого показана
+func csrfToken(secret string, req AuthorizeRequest) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(url.Values{
+		"client_id":             {req.ClientID},
+		"redirect_uri":          {req.RedirectURI},
+		"scope":                 {req.Scope},
+		"state":                 {req.State},
+		"nonce":                 {req.Nonce},
+		"code_challenge":        {req.CodeChallenge},
+		"code_challenge_method": {req.CodeChallengeMethod},
+	}.Encode()))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func loginErrorText(err error) string {
+	var te *ThrottleError
+	switch {
+	case errors.As(err, &te):
+		return fmt.Sprintf("Слишком много попыток, повторите через %d с", int(math.Ceil(te.RetryAfter.Seconds())))
+	case errors.Is(err, ErrInvalidCredentials):
+		return "Неверный логин или пароль"
+	case errors.Is(err, ErrInvalidOTP):
+		return "Неверный код"
+	case errors.Is(err, ErrInvalidChallenge):
+		return "Время на ввод кода истекло, войдите заново"
+	case errors.Is(err, ErrAccountDisabled):
+		return "Учётная запись отключена"
+	case errors.Is(err, ErrLoginSetupRequired):
+		return "Сначала смените пароль или настройте второй фактор в приложении"
+	}
+	return "Ошибка сервера, попробуйте позже"
+}
+
+func redirectAuthorize(w http.ResponseWriter, r *http.Request, req AuthorizeRequest, params url.Values) {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	target := req.RedirectURI
+	if strings.Contains(target, "?") {
+		target += "&" + params.Encode()
+	} else {
+		target += "?" + params.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+type loginPage struct {
+	Client    string
+	CSRF      string
+	Challenge string // задан — шаг ввода кода TOTP
+	Error     string
+	Fatal     bool // форму не показывать
+}
+
+var loginTmpl = template.Must(template.New("login").Parse(`<!doctype html>
+<html lang="ru"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Вход — A7</title>
+<style>body{font-family:sans-serif;max-width:22em;margin:4em auto;padding:0 1em}label,input,button{display:block;width:100%;margin:.4em 0}.err{color:#b00}</style>
+</head><body>
+<h1>Вход</h1>
+{{if .Client}}<p>Приложение «{{.Client}}» запрашивает вход.</p>{{end}}
+{{if .Error}}<p class="err">{{.Error}}</p>{{end}}
+{{if not .Fatal}}<form method="post"><input type="hidden" name="csrf" value="{{.CSRF}}">
+{{if .Challenge}}<input type="hidden" name="challenge" value="{{.Challenge}}">
+<label>Код из приложения или резервный код<input name="otp" autocomplete="one-time-code" autofocus required></label>
+{{else}}<label>Логин<input name="username" autocomplete="username" autofocus required></label>
+<label>Пароль<input name="password" type="password" autocomplete="current-password" required></label>
+{{end}}<button type="submit">Войти</button>
+</form>{{end}}
+</body></html>
+`))
+
+func renderLogin(w http.ResponseWriter, code int, p loginPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	_ = loginTmpl.Execute(w, p)
+}
+
+// userinfo — принимает и токены OIDC-приложений (aud = id приложения), и токены
+// API; приложению отдаётся только то, что разрешают выданные ему scope
+func (h *Handler) userinfo(w http.ResponseWriter, r *http.Request) {
+	tok := authmw.Bearer(r)
+	if tok == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+	c, err := h.mw.ParseAnyAudience(tok)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if c.Principal().ManagerID() == nil {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	scope := c.Scope
+	if c.ForAPI() {
+		scope = strings.Join([]string{scopeOpenID, scopeProfile, scopeEmail}, " ")
+	}
+	info, err := h.svc.UserInfo(c.UserID, scope)
+	if errors.Is(err, ErrUserNotFound) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, info)
+}
+
+// exchangeCode — grant_type=authorization_code на /token
+func (h *Handler) exchangeCode(w http.ResponseWriter, r *http.Request) {
+	in := CodeExchange{
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+	}
+	var ok bool
+	if in.ClientID, in.ClientSecret, ok = r.BasicAuth(); !ok {
+		in.ClientID, in.ClientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	res, err := h.svc.ExchangeCode(in, clientInfo(r))
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, tokenResp{
+		AccessToken:  res.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(res.ExpiresIn.Seconds()),
+		RefreshToken: res.RefreshToken,
+		IDToken:      res.IDToken,
+		Scope:        res.Scope,
+	})
+}
+
+// refreshGrant — grant_type=refresh_token на /token: токены сессий, выданных
+// через OIDC, с аутентификацией клиента как при обмене кода
+func (h *Handler) refreshGrant(w http.ResponseWriter, r *http.Request) {
+	in := RefreshGrant{RefreshToken: r.PostForm.Get("refresh_token")}
+	var ok bool
+	if in.ClientID, in.ClientSecret, ok = r.BasicAuth(); !ok {
+		in.ClientID, in.ClientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	pair, err := h.svc.RefreshOIDC(in, clientInfo(r))
+	if errors.Is(err, ErrInvalidRefresh) || errors.Is(err, ErrRefreshReuse) {
+		err = oauthErr("invalid_grant", "invalid refresh token")
+	}
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, tokenResp{
+		AccessToken:  pair.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(pair.ExpiresIn.Seconds()),
+		RefreshToken: pair.RefreshToken,
+	})
+}
+
+func writeTokenError(w http.ResponseWriter, err error) {
+	var oe *OAuthError
+	switch {
+	case errors.Is(err, ErrInvalidClient):
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+	case errors.As(err, &oe):
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": oe.Code, "error_description": oe.Description})
+	default:
+		http.Error(w, "server error", http.StatusInternalServerError)
+	}
+}
+
+type oidcClientResp struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"name"`
+	Secret       string    `json:"client_secret,omitempty"` // только в ответе на создание
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func newOIDCClientResp(c OIDCClient) oidcClientResp {
+	return oidcClientResp{ID: c.ID, Name: c.Name, Public: c.Public(), RedirectURIs: c.RedirectURIs, CreatedAt: c.CreatedAt}
+}
+
+func (h *Handler) listOIDCClients(w http.ResponseWriter, r *http.Request) {
+	list, err := h.svc.ListOIDCClients()
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]oidcClientResp, 0, len(list))
+	for _, c := range list {
+		out = append(out, newOIDCClientResp(c))
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+type createOIDCClientReq struct {
+	ID           string   `json:"client_id,omitempty"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"` // выдать client_secret (серверное приложение)
+}
+
+func (h *Handler) createOIDCClient(w http.ResponseWriter, r *http.Request) {
+	var req createOIDCClientReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	c, secret, err := h.svc.CreateOIDCClient(NewOIDCClient(req), clientInfo(r))
+	switch {
+	case errors.Is(err, ErrOIDCClientExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ErrInvalidInput), errors.Is(err, ErrInvalidRedirectURI):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	resp := newOIDCClientResp(c)
+	resp.Secret = secret
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusCreated, resp)
+}
+
+func (h *Handler) deleteOIDCClient(w http.ResponseWriter, r *http.Request) {
+	err := h.svc.DeleteOIDCClient(mux.Vars(r)["id"], clientInfo(r))
+	if errors.Is(err, ErrOIDCClientNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
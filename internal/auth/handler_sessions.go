@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"template/internal/middleware/authmw"
+)
+
+// registerSessions — свои сессии (по access-токену) и сессии любого
+// пользователя для администратора (users:manage)
+func (h *Handler) registerSessions(r *mux.Router) {
+	own := func(f http.HandlerFunc) http.Handler { return h.mw.RequireToken(f) }
+	admin := func(f http.HandlerFunc) http.Handler {
+		return h.mw.RequirePermission(authmw.PermUsersManage)(f)
+	}
+	r.Handle("/sessions", own(h.listOwnSessions)).Methods("GET")
+	r.Handle("/sessions", own(h.endOtherSessions)).Methods("DELETE")
+	r.Handle("/sessions/{sid:[A-Za-z0-9_-]+}", own(h.endOwnSession)).Methods("DELETE")
+	r.Handle("/users/{id:[0-9]+}/sessions", admin(h.listUserSessions)).Methods("GET")
+	r.Handle("/users/{id:[0-9]+}/sessions", admin(h.endUserSessions)).Methods("DELETE")
+	r.Handle("/users/{id:[0-9]+}/sessions/{sid:[A-Za-z0-9_-]+}", admin(h.endUserSession)).Methods("DELETE")
+}
+
+type sessionResp struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // сессия, которой принадлежит токен запроса
+	ClientID   string    `json:"client_id,omitempty"`
+}
+
+func writeSessions(w http.ResponseWriter, list []Session, current string) {
+	out := make([]sessionResp, 0, len(list))
+	for _, s := range list {
+		out = append(out, sessionResp{
+			ID:         s.ID,
+			Device:     s.Device,
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == current,
+			ClientID:   s.ClientID,
+		})
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+// sessionOwner — пользователь из токена; у сервисов и ключей интеграций сессий нет
+func sessionOwner(w http.ResponseWriter, r *http.Request) (authmw.Principal, bool) {
+	p, _ := authmw.FromContext(r.Context())
+	if p.ManagerID() == nil {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return p, false
+	}
+	return p, true
+}
+
+func (h *Handler) listOwnSessions(w http.ResponseWriter, r *http.Request) {
+	p, ok := sessionOwner(w, r)
+	if !ok {
+		return
+	}
+	list, err := h.svc.ListSessions(p.UserID)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	writeSessions(w, list, p.SessionID)
+}
+
+// endOtherSessions — выйти на всех устройствах, кроме текущего
+func (h *Handler) endOtherSessions(w http.ResponseWriter, r *http.Request) {
+	p, ok := sessionOwner(w, r)
+	if !ok {
+		return
+	}
+	if err := h.svc.EndOtherSessions(p.UserID, p.SessionID, clientInfo(r)); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) endOwnSession(w http.ResponseWriter, r *http.Request) {
+	p, ok := sessionOwner(w, r)
+	if !ok {
+		return
+	}
+	if err := h.svc.EndSession(p.UserID, mux.Vars(r)["sid"], clientInfo(r)); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listUserSessions(w http.ResponseWriter, r *http.Request) {
+	p, _ := authmw.FromContext(r.Context())
+	list, err := h.svc.ListSessions(userID(r))
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	writeSessions(w, list, p.SessionID)
+}
+
+func (h *Handler) endUserSessions(w http.ResponseWriter, r *http.Request) {
+	// своя текущая сессия администратора не завершается
+	p, _ := authmw.FromContext(r.Context())
+	if err := h.svc.EndOtherSessions(userID(r), p.SessionID, clientInfo(r)); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) endUserSession(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.EndSession(userID(r), mux.Vars(r)["sid"], clientInfo(r)); err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeSessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrUserNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	default:
+		http.Error(w, "server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"template/internal/middleware/authmw"
+)
+
+func TestInternalRoutesRequireService(t *testing.T) {
+	s := newTestService(t)
+	r := mux.NewRouter()
+	NewHandler(s, authmw.New(s.keys, nil), s.keys).RegisterInternal(r.PathPrefix("/internal/auth").Subrouter())
+
+	service := func(id string) string {
+		tok, err := signServiceToken(s.keys, s.repo, id, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	tests := []struct {
+		method, path, token string
+		code                int
+	}{
+		{"GET", "/internal/auth/revocations", "", http.StatusUnauthorized},
+		{"GET", "/internal/auth/revocations", login(t, s, "office", "office").AccessToken, http.StatusForbidden},
+		{"GET", "/internal/auth/revocations", service("billing"), http.StatusForbidden},
+		{"GET", "/internal/auth/revocations", service("logistic"), http.StatusOK},
+		{"GET", "/internal/auth/revocations", service("audit"), http.StatusOK},
+		{"POST", "/internal/auth/api-keys/introspect", service("logistic"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.path, rec.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"template/internal/middleware/authmw"
+)
+
+// registerUsers — администрирование пользователей, всё под users:manage
+func (h *Handler) registerUsers(r *mux.Router) {
+	admin := func(f http.HandlerFunc) http.Handler {
+		return h.mw.RequirePermission(authmw.PermUsersManage)(f)
+	}
+	r.Handle("/users", admin(h.listUsers)).Methods("GET")
+	r.Handle("/users", admin(h.createUser)).Methods("POST")
+	r.Handle("/users/{id:[0-9]+}", admin(h.getUser)).Methods("GET")
+	r.Handle("/users/{id:[0-9]+}", admin(h.updateUser)).Methods("PATCH")
+	r.Handle("/users/{id:[0-9]+}", admin(h.deleteUser)).Methods("DELETE")
+	r.Handle("/users/{id:[0-9]+}/disable", admin(h.setDisabled(true))).Methods("POST")
+	r.Handle("/users/{id:[0-9]+}/enable", admin(h.setDisabled(false))).Methods("POST")
+	r.Handle("/users/{id:[0-9]+}/password", admin(h.resetPassword)).Methods("POST")
+	r.Handle("/users/{id:[0-9]+}/unlock", admin(h.unlock)).Methods("POST")
+}
+
+type userResp struct {
+	ID                 int64      `json:"id"`
+	Username           string     `json:"username"`
+	Email              string     `json:"email"`
+	Role               string     `json:"role"`
+	LogisticsPointID   *int64     `json:"logistics_point_id"`
+	Disabled           bool       `json:"disabled"`
+	MustChangePassword bool       `json:"must_change_password"`
+	TOTPEnabled        bool       `json:"totp_enabled"`
+	LockedUntil        *time.Time `json:"locked_until,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+func newUserResp(m Manager) userResp {
+	return userResp{
+		ID:                 m.ID,
+		Username:           m.Username,
+		Email:              m.Email,
+		Role:               m.Role,
+		LogisticsPointID:   m.LogisticsPointID,
+		Disabled:           m.Disabled,
+		MustChangePassword: m.MustChangePassword,
+		TOTPEnabled:        m.TOTPEnabled,
+		LockedUntil:        m.LockedUntil,
+		CreatedAt:          m.CreatedAt,
+		UpdatedAt:          m.UpdatedAt,
+	}
+}
+
+type userListResp struct {
+	Items  []userResp `json:"items"`
+	Total  int        `json:"total"`
+	Limit  int        `json:"limit"`
+	Offset int        `json:"offset"`
+}
+
+// listUsers — ?q=подстрока логина&role=&disabled=true|false&limit=&offset=
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := UserFilter{Query: q.Get("q"), Role: q.Get("role"), Limit: 50}
+	if v := q.Get("disabled"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "bad disabled", http.StatusBadRequest)
+			return
+		}
+		f.Disabled = &b
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			http.Error(w, "bad limit", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "bad offset", http.StatusBadRequest)
+			return
+		}
+		f.Offset = n
+	}
+	users, total, err := h.svc.ListUsers(f)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	out := userListResp{Items: make([]userResp, 0, len(users)), Total: total, Limit: f.Limit, Offset: f.Offset}
+	for _, u := range users {
+		out.Items = append(out.Items, newUserResp(u))
+	}
+	respondJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	u, err := h.svc.GetUser(userID(r))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, newUserResp(u))
+}
+
+type createUserReq struct {
+	Username           string `json:"username"`
+	Email              string `json:"email,omitempty"`
+	Password           string `json:"password"`
+	Role               string `json:"role"`
+	LogisticsPointID   *int64 `json:"logistics_point_id,omitempty"`
+	MustChangePassword *bool  `json:"must_change_password,omitempty"` // по умолчанию true
+}
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	// пароль задаёт администратор — пусть пользователь сменит его при входе
+	mustChange := req.MustChangePassword == nil || *req.MustChangePassword
+	u, err := h.svc.CreateUser(NewUser{
+		Username:           req.Username,
+		Email:              req.Email,
+		Password:           req.Password,
+		Role:               req.Role,
+		LogisticsPointID:   req.LogisticsPointID,
+		MustChangePassword: mustChange,
+	})
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, newUserResp(u))
+}
+
+type updateUserReq struct {
+	Email *string `json:"email,omitempty"` // "" — удалить адрес
+	Role  *string `json:"role,omitempty"`
+	// null снимает привязку, отсутствие поля — не менять
+	LogisticsPointID json.RawMessage `json:"logistics_point_id,omitempty"`
+}
+
+func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
+	var req updateUserReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	upd := UserUpdate{Email: req.Email, Role: req.Role}
+	if req.LogisticsPointID != nil {
+		upd.SetLogisticsPoint = true
+		if err := json.Unmarshal(req.LogisticsPointID, &upd.LogisticsPointID); err != nil {
+			http.Error(w, "bad logistics_point_id", http.StatusBadRequest)
+			return
+		}
+	}
+	u, err := h.svc.UpdateUser(actorID(r), userID(r), upd, clientInfo(r))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, newUserResp(u))
+}
+
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteUser(actorID(r), userID(r), clientInfo(r)); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) setDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.svc.SetDisabled(actorID(r), userID(r), disabled, clientInfo(r)); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type resetPasswordReq struct {
+	Password string `json:"password"`
+}
+
+// resetPassword — временный пароль; сессии пользователя отзываются,
+// при входе потребуется сменить пароль
+func (h *Handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	if err := h.svc.ResetPassword(userID(r), req.Password, clientInfo(r)); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func userID(r *http.Request) int64 {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	return id
+}
+
+func actorID(r *http.Request) int64 {
+	p, _ := authmw.FromContext(r.Context())
+	return p.UserID
+}
+
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, ErrUsernameTaken), errors.Is(err, ErrEmailTaken), errors.Is(err, ErrSelfModification):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrWeakPassword), errors.Is(err, ErrPasswordReused), errors.Is(err, ErrUnknownRole),
+		errors.Is(err, ErrInvalidInput), errors.Is(err, ErrPointNotAllowed), errors.Is(err, ErrInvalidEmail):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"template/internal/middleware/authmw"
+)
+
+// KeySet — ключи подписи токенов. Подписывает один (активный) ключ,
+// проверяются и публикуются в JWKS все — так ротация проходит без разрыва:
+// новый ключ добавляется заранее, старый удаляется после истечения его токенов.
+//
+// В каталоге ключей:
+//   - <kid>.pem     — закрытый ключ PKCS#8 (RSA или Ed25519);
+//   - <kid>.pub.pem — только открытый ключ (выведенный из подписи, ещё принимается);
+//   - oidc/<kid>.pem — RSA-ключ ID-токенов OIDC, если активный ключ не RSA.
+type KeySet struct {
+	signingKID string
+	idKID      string // ключ RS256 для ID-токенов
+	signers    map[string]crypto.Signer
+	public     map[string]crypto.PublicKey
+}
+
+// LoadKeySet читает ключи из dir. Без dir ключ генерируется в памяти (только для
+// разработки); в пустом dir новый ключ генерируется и сохраняется.
+// Активный ключ — signingKID либо последний по имени.
+func LoadKeySet(dir, signingKID string) (*KeySet, error) {
+	ks := &KeySet{signers: map[string]crypto.Signer{}, public: map[string]crypto.PublicKey{}}
+	if dir == "" {
+		log.Printf("[auth] AUTH_KEYS_DIR not set, using ephemeral signing key")
+		if err := ks.generate(""); err != nil {
+			return nil, err
+		}
+		return ks, ks.loadIDTokenKey("")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := ks.load(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+	}
+	if len(ks.signers) == 0 {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := ks.generate(dir); err != nil {
+			return nil, err
+		}
+	}
+	if signingKID == "" {
+		kids := make([]string, 0, len(ks.signers))
+		for kid := range ks.signers {
+			kids = append(kids, kid)
+		}
+		sort.Strings(kids)
+		signingKID = kids[len(kids)-1]
+	}
+	if _, ok := ks.signers[signingKID]; !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingKID)
+	}
+	ks.signingKID = signingKID
+	return ks, ks.loadIDTokenKey(filepath.Join(dir, "oidc"))
+}
+
+// loadIDTokenKey — ключ ID-токенов (OIDC Core требует RS256): активный, если он RSA,
+// иначе последний по имени RSA-ключ из dir; если там пусто — новый RSA-ключ
+func (ks *KeySet) loadIDTokenKey(dir string) error {
+	if _, ok := ks.signers[ks.signingKID].(*rsa.PrivateKey); ok {
+		ks.idKID = ks.signingKID
+		return nil
+	}
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			if err := ks.load(f); err != nil {
+				return fmt.Errorf("%s: %w", f, err)
+			}
+			kid := strings.TrimSuffix(filepath.Base(f), ".pem")
+			if strings.HasSuffix(kid, ".pub") {
+				continue
+			}
+			if _, ok := ks.signers[kid].(*rsa.PrivateKey); !ok {
+				return fmt.Errorf("%s: RSA key required for ID tokens", f)
+			}
+			ks.idKID = kid
+		}
+		if ks.idKID != "" {
+			return nil
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	kid := "oidc-" + time.Now().UTC().Format("20060102T150405")
+	if err := ks.add(dir, kid, priv); err != nil {
+		return err
+	}
+	ks.idKID = kid
+	return nil
+}
+
+func (ks *KeySet) load(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return errors.New("no PEM block")
+	}
+	name := filepath.Base(path)
+	if kid, ok := strings.CutSuffix(name, ".pub.pem"); ok {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		ks.public[kid] = pub
+		return nil
+	}
+	kid := strings.TrimSuffix(name, ".pem")
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		ks.signers[kid] = k
+	case *rsa.PrivateKey:
+		ks.signers[kid] = k
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	ks.public[kid] = ks.signers[kid].Public()
+	return nil
+}
+
+// generate — новый Ed25519-ключ; kid — дата создания
+func (ks *KeySet) generate(dir string) error {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	kid := time.Now().UTC().Format("20060102T150405")
+	if err := ks.add(dir, kid, priv); err != nil {
+		return err
+	}
+	ks.signingKID = kid
+	return nil
+}
+
+// add — новый ключ в набор; с dir — сохраняется в <dir>/<kid>.pem
+func (ks *KeySet) add(dir, kid string, priv crypto.Signer) error {
+	if dir != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, kid+".pem")
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return err
+		}
+		log.Printf("[auth] generated signing key %s", path)
+	}
+	ks.signers[kid] = priv
+	ks.public[kid] = priv.Public()
+	return nil
+}
+
+// PublicKey реализует authmw.KeySource — auth проверяет свои токены без сети
+func (ks *KeySet) PublicKey(kid string) (crypto.PublicKey, error) {
+	if k, ok := ks.public[kid]; ok {
+		return k, nil
+	}
+	return nil, authmw.ErrUnknownKey
+}
+
+func (ks *KeySet) JWKS() authmw.JWKS {
+	kids := make([]string, 0, len(ks.public))
+	for kid := range ks.public {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	out := authmw.JWKS{Keys: make([]authmw.JWK, 0, len(kids))}
+	for _, kid := range kids {
+		if k, err := authmw.NewJWK(kid, ks.public[kid]); err == nil {
+			out.Keys = append(out.Keys, k)
+		}
+	}
+	return out
+}
+
+// method — алгоритм подписи активного ключа
+func (ks *KeySet) method() jwt.SigningMethod {
+	if _, ok := ks.signers[ks.signingKID].(*rsa.PrivateKey); ok {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// Sign подписывает claims активным ключом
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	signer := ks.signers[ks.signingKID]
+	t := jwt.NewWithClaims(ks.method(), claims)
+	t.Header["kid"] = ks.signingKID
+	return t.SignedString(signer)
+}
+
+// SignRS256 подписывает claims RSA-ключом ID-токенов
+func (ks *KeySet) SignRS256(claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = ks.idKID
+	return t.SignedString(ks.signers[ks.idKID])
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooManyAttempts = errors.New("too many login attempts")
+	ErrAccountLocked   = errors.New("account temporarily locked")
+)
+
+// ThrottleError — вход отклонён до истечения RetryAfter
+type ThrottleError struct {
+	Err        error // ErrTooManyAttempts | ErrAccountLocked
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", e.Err, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottleError) Unwrap() error { return e.Err }
+
+// LockoutPolicy — защита входа от перебора
+type LockoutPolicy struct {
+	MaxFailures int           // неудач подряд по логину до блокировки учётки
+	Lockout     time.Duration // на сколько блокировать
+	BackoffBase time.Duration // пауза после первой неудачи, дальше удваивается
+	BackoffMax  time.Duration
+}
+
+type attempts struct {
+	failures int
+	last     time.Time
+}
+
+// loginLimiter — счётчики неудачных входов по логину и по IP (в памяти процесса)
+// с экспоненциальной паузой между попытками
+type loginLimiter struct {
+	mu     sync.Mutex
+	policy LockoutPolicy
+	byKey  map[string]*attempts
+}
+
+func newLoginLimiter(p LockoutPolicy) *loginLimiter {
+	return &loginLimiter{policy: p, byKey: map[string]*attempts{}}
+}
+
+// wait — сколько ещё ждать до следующей попытки
+func (l *loginLimiter) wait(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a, ok := l.byKey[key]
+	if !ok || a.failures == 0 {
+		return 0
+	}
+	return a.last.Add(l.backoff(a.failures)).Sub(now)
+}
+
+// fail — учесть неудачу; возвращает число неудач подряд
+func (l *loginLimiter) fail(key string, now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.byKey) > 10000 {
+		l.prune(now)
+	}
+	a, ok := l.byKey[key]
+	if !ok {
+		a = &attempts{}
+		l.byKey[key] = a
+	}
+	a.failures++
+	a.last = now
+	return a.failures
+}
+
+func (l *loginLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.byKey, key)
+}
+
+func (l *loginLimiter) backoff(failures int) time.Duration {
+	d := l.policy.BackoffBase
+	for i := 1; i < failures && d < l.policy.BackoffMax; i++ {
+		d *= 2
+	}
+	if d > l.policy.BackoffMax {
+		d = l.policy.BackoffMax
+	}
+	return d
+}
+
+// prune — забыть давно молчащие ключи
+func (l *loginLimiter) prune(now time.Time) {
+	for k, a := range l.byKey {
+		if now.Sub(a.last) > l.policy.BackoffMax+l.policy.Lockout {
+			delete(l.byKey, k)
+		}
+	}
+}
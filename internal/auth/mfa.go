@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"template/internal/middleware/authmw"
+)
+
+var (
+	ErrInvalidChallenge  = errors.New("invalid or expired challenge")
+	ErrInvalidOTP        = errors.New("invalid code")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication not enrolled")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	ErrMFAMandatory      = errors.New("two-factor authentication is mandatory for this role")
+)
+
+const (
+	// challengeIssuer отличается от authmw.Issuer: challenge-токен
+	// не пройдёт проверку как access-токен ни в одном сервисе
+	challengeIssuer    = authmw.Issuer + "/mfa"
+	challengeTTL       = 5 * time.Minute
+	challengeVerify    = "verify"   // ввести код второго фактора
+	challengeEnroll    = "enroll"   // второй фактор обязателен, но не настроен
+	challengePassword  = "password" // сначала сменить пароль
+	recoveryCodesCount = 10
+	totpIssuer         = "A7"
+)
+
+// MFAPolicy — роли, для которых второй фактор обязателен
+type MFAPolicy map[string]bool
+
+// ParseMFARoles разбирает список ролей через запятую
+func ParseMFARoles(spec string) MFAPolicy {
+	out := MFAPolicy{}
+	for _, r := range strings.Split(spec, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			out[r] = true
+		}
+	}
+	return out
+}
+
+// LoginResult — итог входа по паролю: пара токенов либо Challenge
+type LoginResult struct {
+	Tokens    TokenPair
+	Challenge *Challenge
+}
+
+// Challenge — короткоживущий токен второго шага входа
+type Challenge struct {
+	Token     string
+	ExpiresIn time.Duration
+	// Enroll — сначала настроить TOTP (/auth/2fa/enroll, /auth/2fa/confirm)
+	Enroll bool
+	// PasswordChange — сначала сменить пароль (/auth/password/change)
+	PasswordChange bool
+}
+
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+type challengeClaims struct {
+	UserID  int64  `json:"uid"`
+	Purpose string `json:"mfa"`
+	jwt.RegisteredClaims
+}
+
+// completeLogin — пароль проверен: выдаём токены или требуем второй фактор
+func (s *service) completeLogin(u Manager, client ClientInfo) (LoginResult, error) {
+	purpose := s.loginStep(u)
+	if purpose == "" {
+		s.succeeded(u, client.IP)
+		pair, err := s.startSession(u, client)
+		return LoginResult{Tokens: pair}, err
+	}
+	c, err := s.challenge(u, purpose)
+	return LoginResult{Challenge: c}, err
+}
+
+// loginStep — что ещё нужно после пароля (назначение challenge); пусто — ничего
+func (s *service) loginStep(u Manager) string {
+	switch {
+	case u.MustChangePassword:
+		return challengePassword
+	case u.TOTPEnabled:
+		return challengeVerify
+	case s.mfa[u.Role]:
+		return challengeEnroll
+	}
+	return ""
+}
+
+func (s *service) challenge(u Manager, purpose string) (*Challenge, error) {
+	now := time.Now()
+	tok, err := s.keys.Sign(challengeClaims{
+		UserID:  u.ID,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   u.Username,
+			Issuer:    challengeIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(challengeTTL)),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Challenge{
+		Token:          tok,
+		ExpiresIn:      challengeTTL,
+		Enroll:         purpose == challengeEnroll,
+		PasswordChange: purpose == challengePassword,
+	}, nil
+}
+
+// parseChallenge — пользователь из challenge-токена с нужным назначением
+func (s *service) parseChallenge(tok, purpose string) (Manager, error) {
+	var c challengeClaims
+	_, err := jwt.ParseWithClaims(tok, &c, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return s.keys.PublicKey(kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(challengeIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || c.Purpose != purpose {
+		return Manager{}, ErrInvalidChallenge
+	}
+	u, ok := s.activeUser(c.UserID)
+	if !ok {
+		return Manager{}, ErrInvalidChallenge
+	}
+	// challenge действует, пока шаг ещё нужен: после подтверждения TOTP или
+	// смены пароля повторно его не предъявить
+	switch purpose {
+	case challengePassword:
+		ok = u.MustChangePassword
+	case challengeEnroll:
+		ok = !u.MustChangePassword && !u.TOTPEnabled
+	case challengeVerify:
+		ok = !u.MustChangePassword && u.TOTPEnabled
+	}
+	if !ok {
+		return Manager{}, ErrInvalidChallenge
+	}
+	return u, nil
+}
+
+func (s *service) LoginMFA(challenge, code string, client ClientInfo) (TokenPair, error) {
+	u, err := s.verifyMFA(challenge, code, client)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return s.startSession(u, client)
+}
+
+// verifyMFA — проверка второго шага входа с теми же ограничениями перебора, что у пароля
+func (s *service) verifyMFA(challenge, code string, client ClientInfo) (Manager, error) {
+	u, err := s.challengeUser(challenge, challengeVerify, client.IP)
+	if err != nil {
+		return Manager{}, err
+	}
+	if err := s.checkCode(u, client, func(now time.Time) (bool, error) { return s.secondFactor(u, code, client, now) }); err != nil {
+		return Manager{}, err
+	}
+	s.succeeded(u, client.IP)
+	return u, nil
+}
+
+// challengeUser — parseChallenge; негодный challenge считается неудачной попыткой с этого IP
+func (s *service) challengeUser(challenge, purpose, ip string) (Manager, error) {
+	now := time.Now()
+	if d := s.limiter.wait("ip:"+ip, now); d > 0 {
+		return Manager{}, &ThrottleError{Err: ErrTooManyAttempts, RetryAfter: d}
+	}
+	u, err := s.parseChallenge(challenge, purpose)
+	if err != nil {
+		s.limiter.fail("ip:"+ip, now)
+	}
+	return u, err
+}
+
+// checkCode — проверка кода (TOTP или резервного) с теми же паузами и блокировкой,
+// что у пароля; неверный код — s.failed
+func (s *service) checkCode(u Manager, client ClientInfo, verify func(now time.Time) (bool, error)) error {
+	now := time.Now()
+	if err := s.wait(u.Username, client.IP, now); err != nil {
+		return err
+	}
+	if err := locked(u, now); err != nil {
+		return err
+	}
+	ok, err := verify(now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.failed(u, client, now)
+		return ErrInvalidOTP
+	}
+	return nil
+}
+
+// secondFactor — код TOTP либо неиспользованный резервный код
+func (s *service) secondFactor(u Manager, code string, client ClientInfo, now time.Time) (bool, error) {
+	if step, ok := verifyTOTP(u.TOTPSecret, code, now, u.TOTPLastStep); ok {
+		return s.repo.UseTOTPStep(u.ID, step)
+	}
+	ok, err := s.repo.UseRecoveryCode(u.ID, hashRecoveryCode(code))
+	if ok {
+		s.emit(client, "auth.mfa_recovery_code_used", u.Username, http.StatusOK, "")
+	}
+	return ok, err
+}
+
+func (s *service) EnrollmentUser(challenge string) (int64, error) {
+	u, err := s.parseChallenge(challenge, challengeEnroll)
+	return u.ID, err
+}
+
+func (s *service) EnrollTOTP(userID int64) (TOTPEnrollment, error) {
+	u, ok := s.repo.ByID(userID)
+	if !ok {
+		return TOTPEnrollment{}, ErrUserNotFound
+	}
+	if u.TOTPEnabled {
+		return TOTPEnrollment{}, ErrMFAAlreadyEnabled
+	}
+	// секрет сохраняется выключенным до подтверждения кодом
+	secret := newTOTPSecret()
+	if err := s.repo.SetTOTP(u.ID, secret, false); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return TOTPEnrollment{Secret: secret, URI: totpURI(totpIssuer, u.Username, secret)}, nil
+}
+
+func (s *service) ConfirmTOTP(userID int64, code string, client ClientInfo) ([]string, error) {
+	u, ok := s.repo.ByID(userID)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return s.confirm(u, code, client)
+}
+
+func (s *service) ConfirmEnrollment(challenge, code string, client ClientInfo) ([]string, TokenPair, error) {
+	u, err := s.challengeUser(challenge, challengeEnroll, client.IP)
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+	// код подтверждения — тоже шаг входа: неверные коды считаются попытками
+	if err := s.checkCode(u, client, func(now time.Time) (bool, error) {
+		_, ok := verifyTOTP(u.TOTPSecret, code, now, u.TOTPLastStep)
+		return ok || u.TOTPSecret == "", nil
+	}); err != nil {
+		return nil, TokenPair{}, err
+	}
+	codes, err := s.confirm(u, code, client)
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+	s.succeeded(u, client.IP)
+	pair, err := s.startSession(u, client)
+	return codes, pair, err
+}
+
+func (s *service) confirm(u Manager, code string, client ClientInfo) ([]string, error) {
+	if u.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if u.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+	step, ok := verifyTOTP(u.TOTPSecret, code, time.Now(), u.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidOTP
+	}
+	if err := s.repo.SetTOTP(u.ID, u.TOTPSecret, true); err != nil {
+		return nil, err
+	}
+	if _, err := s.repo.UseTOTPStep(u.ID, step); err != nil {
+		return nil, err
+	}
+	codes, err := s.resetRecoveryCodes(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.emit(client, "auth.mfa_enabled", u.Username, http.StatusOK, "")
+	return codes, nil
+}
+
+func (s *service) DisableTOTP(userID int64, code string, client ClientInfo) error {
+	u, err := s.enabledUser(userID)
+	if err != nil {
+		return err
+	}
+	if s.mfa[u.Role] {
+		return ErrMFAMandatory
+	}
+	if err := s.checkCode(u, client, func(now time.Time) (bool, error) { return s.secondFactor(u, code, client, now) }); err != nil {
+		return err
+	}
+	if err := s.repo.SetTOTP(u.ID, "", false); err != nil {
+		return err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(u.ID, nil); err != nil {
+		return err
+	}
+	s.emit(client, "auth.mfa_disabled", u.Username, http.StatusOK, "")
+	return nil
+}
+
+func (s *service) RegenerateRecoveryCodes(userID int64, code string, client ClientInfo) ([]string, error) {
+	u, err := s.enabledUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	// только TOTP: резервным кодом новые резервные коды не получить
+	if err := s.checkCode(u, client, func(now time.Time) (bool, error) {
+		step, ok := verifyTOTP(u.TOTPSecret, code, now, u.TOTPLastStep)
+		if !ok {
+			return false, nil
+		}
+		return s.repo.UseTOTPStep(u.ID, step)
+	}); err != nil {
+		return nil, err
+	}
+	return s.resetRecoveryCodes(u.ID)
+}
+
+func (s *service) enabledUser(userID int64) (Manager, error) {
+	u, ok := s.repo.ByID(userID)
+	if !ok {
+		return Manager{}, ErrUserNotFound
+	}
+	if !u.TOTPEnabled {
+		return Manager{}, ErrMFANotEnrolled
+	}
+	return u, nil
+}
+
+// resetRecoveryCodes — новый набор резервных кодов; в хранилище только хеши
+func (s *service) resetRecoveryCodes(userID int64) ([]string, error) {
+	codes := newRecoveryCodes(recoveryCodesCount)
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashRecoveryCode(c)
+	}
+	if err := s.repo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
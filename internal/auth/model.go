@@ -1,8 +1,90 @@
 package auth
 
+import (
+	"time"
+
+	"template/internal/middleware/authmw"
+)
+
 type Manager struct {
 	ID       int64
 	Username string
-	Password string // для моков — храним в открытую
-	Role     string // "office_manager" | "logistics_manager"
+	// Email — адрес для восстановления пароля; пусто — восстановление недоступно
+	Email    string
+	Password string // bcrypt-хеш пароля
+	Role     string // "admin" | "office_manager" | "logistics_manager"
+	// LogisticsPointID — логточка менеджера логистики, попадает в токен (lpid)
+	LogisticsPointID *int64
+	// Disabled — учётка выключена администратором: вход и refresh запрещены
+	Disabled bool
+	// MustChangePassword — при следующем входе сначала сменить пароль
+	MustChangePassword bool
+	// LockedUntil — временная блокировка после серии неудачных входов
+	LockedUntil *time.Time
+	// TOTPSecret — base32-секрет TOTP; задан, но не TOTPEnabled — ждёт подтверждения
+	TOTPSecret  string
+	TOTPEnabled bool
+	// TOTPLastStep — последний принятый шаг TOTP, защита от повтора кода
+	TOTPLastStep int64
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// defaultRolePermissions — исходное сопоставление ролей разрешениям; каждая
+// пара засевается в БД один раз (и в уже работающие БД, если добавлена позже),
+// дальше правится в таблице role_permissions
+var defaultRolePermissions = map[string][]string{
+	authmw.RoleAdmin: authmw.Permissions,
+	authmw.RoleOfficeManager: {
+		authmw.PermApplicationsRead, authmw.PermApplicationsCreate, authmw.PermApplicationsUpdateStatus,
+		authmw.PermApplicationsUpdate,
+	},
+	authmw.RoleLogisticsManager: {
+		authmw.PermLogisticApplicationsRead, authmw.PermLogisticApplicationsUpdateStatus,
+		authmw.PermRoutesCreate, authmw.PermRoutesAssign, authmw.PermRoutesSend,
+	},
+	authmw.RoleService: {
+		authmw.PermAuditWrite,
+	},
+}
+
+// UserFilter — выборка пользователей для администрирования
+type UserFilter struct {
+	Query    string // подстрока логина или email
+	Role     string
+	Disabled *bool
+	Limit    int
+	Offset   int
+}
+
+// RefreshToken — непрозрачный refresh-токен; в хранилище только SHA-256 от него.
+// Все токены, выпущенные ротацией от одного входа, образуют семейство (FamilyID).
+type RefreshToken struct {
+	ID        int64
+	UserID    int64
+	FamilyID  string
+	Hash      string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// PasswordResetToken — одноразовый токен сброса пароля из письма; хранится SHA-256.
+// У пользователя действует только последний выданный.
+type PasswordResetToken struct {
+	ID        int64
+	UserID    int64
+	Hash      string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+	Role         string
+	SessionID    string
 }
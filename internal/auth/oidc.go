@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDC: auth — минимальный провайдер OpenID Connect (authorization code + PKCE).
+// Access- и refresh-токены те же, что у /auth/login; ID-токен подписывается
+// RS256 (KeySet.SignRS256) и с iss = Options.OIDCIssuer (URL), поэтому как
+// access-токен он не принимается.
+
+var (
+	ErrOIDCClientNotFound = errors.New("oidc client not found")
+	ErrOIDCClientExists   = errors.New("oidc client already exists")
+	ErrInvalidRedirectURI = errors.New("redirect_uri is not registered for the client")
+	// ErrLoginSetupRequired — сначала сменить пароль или настроить TOTP через /auth/login
+	ErrLoginSetupRequired = errors.New("password change or 2fa enrollment required")
+)
+
+// OAuthError — ошибка в терминах OAuth 2.0 (error, error_description)
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string { return e.Code + ": " + e.Description }
+
+func oauthErr(code, desc string) error { return &OAuthError{Code: code, Description: desc} }
+
+const (
+	authCodeTTL = time.Minute
+	// поддерживаемые scope; openid обязателен
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
+// OIDCClient — зарегистрированное приложение
+type OIDCClient struct {
+	ID           string
+	Name         string
+	SecretHash   string // пусто — публичный клиент (SPA, CLI): только с PKCE
+	RedirectURIs []string
+	CreatedAt    time.Time
+}
+
+func (c OIDCClient) Public() bool { return c.SecretHash == "" }
+
+// NewOIDCClient — параметры регистрации
+type NewOIDCClient struct {
+	ID           string // пусто — сгенерировать
+	Name         string
+	RedirectURIs []string
+	Confidential bool // выдать секрет
+}
+
+// AuthorizeRequest — параметры /authorize
+type AuthorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthCode — код авторизации; хранится SHA-256, живёт authCodeTTL, одноразовый
+type AuthCode struct {
+	Hash          string
+	ClientID      string
+	UserID        int64
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
+}
+
+// CodeExchange — запрос grant_type=authorization_code
+type CodeExchange struct {
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	ClientSecret string
+	CodeVerifier string
+}
+
+// RefreshGrant — запрос grant_type=refresh_token
+type RefreshGrant struct {
+	RefreshToken string
+	ClientID     string
+	ClientSecret string
+}
+
+// OIDCTokens — ответ token endpoint
+type OIDCTokens struct {
+	TokenPair
+	IDToken string
+	Scope   string
+}
+
+// AuthorizeResult — после пароля либо код, либо нужен второй фактор
+type AuthorizeResult struct {
+	Code      string
+	Challenge *Challenge
+}
+
+var clientIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,63}$`)
+
+// ParseOIDCClients — публичные клиенты из конфигурации:
+// "fe=http://localhost:8080/callback|http://localhost:8080/silent,cli=http://127.0.0.1:8400/cb"
+func ParseOIDCClients(spec string) ([]OIDCClient, error) {
+	var out []OIDCClient
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, uris, ok := strings.Cut(part, "=")
+		if !ok || !clientIDRe.MatchString(id) {
+			return nil, errors.New("oidc clients: expected id=redirect_uri[|redirect_uri...]")
+		}
+		c := OIDCClient{ID: id, Name: id, RedirectURIs: strings.Split(uris, "|")}
+		if err := checkRedirectURIs(c.RedirectURIs); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// checkRedirectURIs — абсолютные URI без фрагмента; сравниваются потом целиком
+func checkRedirectURIs(uris []string) error {
+	if len(uris) == 0 {
+		return ErrInvalidRedirectURI
+	}
+	for _, s := range uris {
+		u, err := url.Parse(s)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+			return ErrInvalidRedirectURI
+		}
+	}
+	return nil
+}
+
+func (s *service) CreateOIDCClient(in NewOIDCClient, client ClientInfo) (OIDCClient, string, error) {
+	c := OIDCClient{ID: strings.TrimSpace(in.ID), Name: strings.TrimSpace(in.Name), RedirectURIs: in.RedirectURIs}
+	if c.ID == "" {
+		c.ID = "c" + hashToken(randomToken(16))[:15]
+	}
+	if !clientIDRe.MatchString(c.ID) || c.Name == "" {
+		return OIDCClient{}, "", ErrInvalidInput
+	}
+	if err := checkRedirectURIs(c.RedirectURIs); err != nil {
+		return OIDCClient{}, "", err
+	}
+	secret := ""
+	if in.Confidential {
+		secret = randomToken(32)
+		c.SecretHash = hashToken(secret)
+	}
+	c, err := s.repo.CreateOIDCClient(c)
+	if err != nil {
+		return OIDCClient{}, "", err
+	}
+	s.emit(client, "auth.oidc_client_created", c.ID, http.StatusCreated, "")
+	return c, secret, nil
+}
+
+func (s *service) ListOIDCClients() ([]OIDCClient, error) { return s.repo.ListOIDCClients() }
+
+func (s *service) DeleteOIDCClient(id string, client ClientInfo) error {
+	if err := s.repo.DeleteOIDCClient(id); err != nil {
+		return err
+	}
+	s.emit(client, "auth.oidc_client_deleted", id, http.StatusOK, "")
+	return nil
+}
+
+// CheckAuthorize — проверка запроса /authorize. ErrOIDCClientNotFound и
+// ErrInvalidRedirectURI нельзя отдавать редиректом (адрес не доверенный),
+// *OAuthError — можно.
+func (s *service) CheckAuthorize(req AuthorizeRequest) (OIDCClient, error) {
+	c, ok := s.repo.OIDCClientByID(req.ClientID)
+	if !ok {
+		return OIDCClient{}, ErrOIDCClientNotFound
+	}
+	if !slices.Contains(c.RedirectURIs, req.RedirectURI) {
+		return OIDCClient{}, ErrInvalidRedirectURI
+	}
+	if !slices.Contains(strings.Fields(req.Scope), scopeOpenID) {
+		return c, oauthErr("invalid_scope", "scope must include openid")
+	}
+	switch {
+	case req.CodeChallenge == "" && c.Public():
+		return c, oauthErr("invalid_request", "code_challenge required for public clients")
+	case req.CodeChallenge != "" && req.CodeChallengeMethod != "S256":
+		return c, oauthErr("invalid_request", "code_challenge_method must be S256")
+	}
+	return c, nil
+}
+
+// AuthorizeLogin — пароль на странице входа провайдера
+func (s *service) AuthorizeLogin(req AuthorizeRequest, username, password string, client ClientInfo) (AuthorizeResult, error) {
+	if _, err := s.CheckAuthorize(req); err != nil {
+		return AuthorizeResult{}, err
+	}
+	u, err := s.checkCredentials(username, password, client)
+	if err != nil {
+		return AuthorizeResult{}, err
+	}
+	switch s.loginStep(u) {
+	case "":
+		s.succeeded(u, client.IP)
+		code, err := s.issueCode(req, u)
+		return AuthorizeResult{Code: code}, err
+	case challengeVerify:
+		c, err := s.challenge(u, challengeVerify)
+		return AuthorizeResult{Challenge: c}, err
+	default:
+		return AuthorizeResult{}, ErrLoginSetupRequired
+	}
+}
+
+// AuthorizeMFA — второй шаг входа на странице провайдера
+func (s *service) AuthorizeMFA(req AuthorizeRequest, challenge, code string, client ClientInfo) (string, error) {
+	if _, err := s.CheckAuthorize(req); err != nil {
+		return "", err
+	}
+	u, err := s.verifyMFA(challenge, code, client)
+	if err != nil {
+		return "", err
+	}
+	return s.issueCode(req, u)
+}
+
+func (s *service) issueCode(req AuthorizeRequest, u Manager) (string, error) {
+	code := randomToken(32)
+	now := time.Now()
+	if err := s.repo.SaveAuthCode(AuthCode{
+		Hash:          hashToken(code),
+		ClientID:      req.ClientID,
+		UserID:        u.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(authCodeTTL),
+	}); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ExchangeCode — код на токены: новая сессия, как при обычном входе, и ID-токен
+func (s *service) ExchangeCode(in CodeExchange, client ClientInfo) (OIDCTokens, error) {
+	c, err := s.authenticateOIDCClient(in.ClientID, in.ClientSecret)
+	if err != nil {
+		return OIDCTokens{}, err
+	}
+	code, ok, err := s.repo.UseAuthCode(hashToken(in.Code))
+	if err != nil {
+		return OIDCTokens{}, err
+	}
+	invalid := oauthErr("invalid_grant", "invalid, expired or used code")
+	if !ok || code.ClientID != c.ID || time.Now().After(code.ExpiresAt) {
+		return OIDCTokens{}, invalid
+	}
+	if code.RedirectURI != in.RedirectURI {
+		return OIDCTokens{}, oauthErr("invalid_grant", "redirect_uri mismatch")
+	}
+	switch {
+	case code.CodeChallenge != "" && !pkceValid(in.CodeVerifier, code.CodeChallenge):
+		return OIDCTokens{}, oauthErr("invalid_grant", "code_verifier mismatch")
+	case code.CodeChallenge == "" && in.CodeVerifier != "":
+		// код выдан без PKCE — verifier при обмене означает подмену запроса (RFC 9700)
+		return OIDCTokens{}, oauthErr("invalid_grant", "code_verifier without code_challenge")
+	}
+	u, ok := s.activeUser(code.UserID)
+	if !ok {
+		return OIDCTokens{}, invalid
+	}
+	pair, err := s.startClientSession(u, client, c.ID, code.Scope)
+	if err != nil {
+		return OIDCTokens{}, err
+	}
+	idToken, err := s.idToken(u, code, pair.SessionID)
+	if err != nil {
+		return OIDCTokens{}, err
+	}
+	s.emit(client, "auth.oidc_login", u.Username, http.StatusOK, "client="+c.ID)
+	return OIDCTokens{TokenPair: pair, IDToken: idToken, Scope: code.Scope}, nil
+}
+
+// RefreshOIDC — refresh_token на /auth/token: только приложение, которому выдан
+// токен; конфиденциальное — с секретом
+func (s *service) RefreshOIDC(in RefreshGrant, client ClientInfo) (TokenPair, error) {
+	c, err := s.authenticateOIDCClient(in.ClientID, in.ClientSecret)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return s.refresh(in.RefreshToken, c.ID, client)
+}
+
+// authenticateOIDCClient — клиент по id; у конфиденциального проверяется секрет
+func (s *service) authenticateOIDCClient(id, secret string) (OIDCClient, error) {
+	c, ok := s.repo.OIDCClientByID(id)
+	if !ok || (!c.Public() && subtle.ConstantTimeCompare([]byte(c.SecretHash), []byte(hashToken(secret))) != 1) {
+		return OIDCClient{}, ErrInvalidClient
+	}
+	return c, nil
+}
+
+// pkceValid — S256: BASE64URL(SHA256(verifier)) == challenge (RFC 7636)
+func pkceValid(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	got := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(got), []byte(challenge)) == 1
+}
+
+func (s *service) idToken(u Manager, code AuthCode, sid string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":       s.issuer,
+		"sub":       strconv.FormatInt(u.ID, 10),
+		"aud":       code.ClientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(s.ttl).Unix(),
+		"auth_time": code.AuthTime.Unix(),
+		"sid":       sid,
+		"role":      u.Role,
+	}
+	if code.Nonce != "" {
+		claims["nonce"] = code.Nonce
+	}
+	scopes := strings.Fields(code.Scope)
+	if slices.Contains(scopes, scopeProfile) {
+		claims["preferred_username"] = u.Username
+	}
+	if slices.Contains(scopes, scopeEmail) && u.Email != "" {
+		claims["email"] = u.Email
+	}
+	return s.keys.SignRS256(claims)
+}
+
+// UserInfo — claims для /userinfo по пользователю access-токена в пределах
+// выданных токену scope
+func (s *service) UserInfo(userID int64, scope string) (map[string]any, error) {
+	u, ok := s.activeUser(userID)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	info := map[string]any{"sub": strconv.FormatInt(u.ID, 10)}
+	scopes := strings.Fields(scope)
+	if slices.Contains(scopes, scopeProfile) {
+		info["preferred_username"] = u.Username
+		info["role"] = u.Role
+		if u.LogisticsPointID != nil {
+			info["logistics_point_id"] = *u.LogisticsPointID
+		}
+	}
+	if slices.Contains(scopes, scopeEmail) && u.Email != "" {
+		info["email"] = u.Email
+	}
+	return info, nil
+}
+
+func (s *service) OIDCIssuer() string { return s.issuer }
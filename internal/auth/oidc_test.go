@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"template/internal/middleware/authmw"
+)
+
+func TestPKCEValid(t *testing.T) {
+	// RFC 7636, приложение B
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+	if !pkceValid(verifier, challenge) {
+		t.Fatal("RFC 7636 appendix B vector rejected")
+	}
+	if pkceValid(verifier[:len(verifier)-1]+"Y", challenge) {
+		t.Error("wrong verifier accepted")
+	}
+	if pkceValid(verifier, "") {
+		t.Error("empty challenge accepted")
+	}
+	tests := []struct {
+		n  int
+		ok bool
+	}{{42, false}, {43, true}, {128, true}, {129, false}}
+	for _, tt := range tests {
+		v := strings.Repeat("a", tt.n)
+		if got := pkceValid(v, pkceChallenge(v)); got != tt.ok {
+			t.Errorf("verifier of %d chars: got %v, want %v", tt.n, got, tt.ok)
+		}
+	}
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+const testRedirect = "https://app.example/cb"
+
+// oidcCode — код авторизации для office со scope и challenge
+func oidcCode(t *testing.T, s *service, clientID, scope, challenge string) string {
+	t.Helper()
+	req := AuthorizeRequest{ClientID: clientID, RedirectURI: testRedirect, Scope: scope, CodeChallenge: challenge}
+	if challenge != "" {
+		req.CodeChallengeMethod = "S256"
+	}
+	res, err := s.AuthorizeLogin(req, "office", "office", ClientInfo{IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Code
+}
+
+func TestOIDCAccessTokenIsClientBound(t *testing.T) {
+	s := newTestService(t)
+	mw := authmw.New(s.keys, nil)
+	if _, _, err := s.CreateOIDCClient(NewOIDCClient{ID: "app", Name: "App", RedirectURIs: []string{testRedirect}}, ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	verifier := strings.Repeat("v", 43)
+	code := oidcCode(t, s, "app", "openid email", pkceChallenge(verifier))
+	res, err := s.ExchangeCode(CodeExchange{Code: code, RedirectURI: testRedirect, ClientID: "app", CodeVerifier: verifier}, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mw.Parse(res.AccessToken); err == nil {
+		t.Fatal("API accepts an OIDC client's access token")
+	}
+	c, err := mw.ParseAnyAudience(res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ForAPI() || !slices.Equal(c.Audience, []string{"app"}) || c.Scope != "openid email" || len(c.Permissions) != 0 {
+		t.Fatalf("bad claims: aud=%v scope=%q perms=%v", c.Audience, c.Scope, c.Permissions)
+	}
+
+	pair, err := s.RefreshOIDC(RefreshGrant{RefreshToken: res.RefreshToken, ClientID: "app"}, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := mw.ParseAnyAudience(pair.AccessToken); err != nil || c.ForAPI() || c.Scope != "openid email" {
+		t.Fatalf("refreshed token: %+v, %v", c, err)
+	}
+
+	if c, err := mw.Parse(login(t, s, "office", "office").AccessToken); err != nil || len(c.Permissions) == 0 {
+		t.Fatalf("first-party token: %+v, %v", c, err)
+	}
+}
+
+func TestUserInfoScopes(t *testing.T) {
+	s := newTestService(t)
+	u, err := s.CreateUser(NewUser{Username: "bob", Email: "bob@example.com", Password: "password-1", Role: "office_manager"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		scope string
+		want  []string
+	}{
+		{"openid", []string{"sub"}},
+		{"openid email", []string{"email", "sub"}},
+		{"openid profile", []string{"preferred_username", "role", "sub"}},
+	}
+	for _, tt := range tests {
+		info, err := s.UserInfo(u.ID, tt.scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for k := range info {
+			got = append(got, k)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("scope %q: got %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestExchangeCodeRejectsVerifierWithoutChallenge(t *testing.T) {
+	s := newTestService(t)
+	_, secret, err := s.CreateOIDCClient(NewOIDCClient{ID: "app", Name: "App", RedirectURIs: []string{testRedirect}, Confidential: true}, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := oidcCode(t, s, "app", "openid", "")
+	_, err = s.ExchangeCode(CodeExchange{Code: code, RedirectURI: testRedirect, ClientID: "app", ClientSecret: secret, CodeVerifier: strings.Repeat("v", 43)}, ClientInfo{})
+	var oe *OAuthError
+	if !errors.As(err, &oe) || oe.Code != "invalid_grant" {
+		t.Fatalf("got %v, want invalid_grant", err)
+	}
+}
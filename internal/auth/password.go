@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrWeakPassword = errors.New("password does not satisfy policy")
+
+// Hasher — хеширование паролей bcrypt с заданной стоимостью
+type Hasher struct {
+	cost      int
+	dummyHash []byte // той же стоимости, что и настоящие хеши
+}
+
+func NewHasher(cost int) Hasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	dummy, _ := bcrypt.GenerateFromPassword([]byte("dummy-password"), cost)
+	return Hasher{cost: cost, dummyHash: dummy}
+}
+
+func (h Hasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Verify сравнивает пароль с хешем; rehash=true, если хеш надо пересчитать
+// (сменилась стоимость или в хранилище остался пароль в открытую)
+func (h Hasher) Verify(hash, password string) (ok, rehash bool) {
+	if !isBcrypt(hash) {
+		ok = subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+		return ok, ok
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err != nil || cost != h.cost
+}
+
+// dummy — для выравнивания времени ответа, когда пользователя нет: сравнение
+// с хешем той же стоимости длится столько же, сколько настоящее
+func (h Hasher) dummy(password string) {
+	_ = bcrypt.CompareHashAndPassword(h.dummyHash, []byte(password))
+}
+
+func isBcrypt(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// PasswordPolicy — требования к новым паролям
+type PasswordPolicy struct {
+	MinLength  int
+	MinClasses int // сколько классов символов из четырёх: строчные, заглавные, цифры, прочие
+	breached   map[string]struct{}
+}
+
+// LoadPasswordPolicy читает список утёкших паролей: по строке на пароль
+// либо SHA-1 в hex (формат HIBP "HASH:count")
+func LoadPasswordPolicy(minLength, minClasses int, breachedFile string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{MinLength: minLength, MinClasses: minClasses, breached: map[string]struct{}{}}
+	if breachedFile == "" {
+		return p, nil
+	}
+	f, err := os.Open(breachedFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if h, _, _ := strings.Cut(line, ":"); isSHA1Hex(h) {
+			p.breached[strings.ToUpper(h)] = struct{}{}
+			continue
+		}
+		p.breached[sha1Hex(line)] = struct{}{}
+	}
+	return p, sc.Err()
+}
+
+func (p *PasswordPolicy) Validate(username, password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: at least %d characters required", ErrWeakPassword, p.MinLength)
+	}
+	if n := charClasses(password); n < p.MinClasses {
+		return fmt.Errorf("%w: at least %d character classes required", ErrWeakPassword, p.MinClasses)
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("%w: must not contain username", ErrWeakPassword)
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return fmt.Errorf("%w: password found in breach list", ErrWeakPassword)
+	}
+	return nil
+}
+
+func charClasses(s string) int {
+	var lower, upper, digit, other bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	n := 0
+	for _, b := range []bool{lower, upper, digit, other} {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
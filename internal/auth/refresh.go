@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	ErrInvalidRefresh = errors.New("invalid refresh token")
+	ErrRefreshReuse   = errors.New("refresh token reuse detected")
+)
+
+func (s *service) Refresh(refreshToken string, client ClientInfo) (TokenPair, error) {
+	return s.refresh(refreshToken, "", client)
+}
+
+// refresh — обмен refresh-токена сессии приложения clientID (пусто — не OIDC);
+// токен чужого приложения не принимается
+func (s *service) refresh(refreshToken, clientID string, client ClientInfo) (TokenPair, error) {
+	t, ok := s.repo.RefreshByHash(hashToken(refreshToken))
+	if !ok || t.RevokedAt != nil || time.Now().After(t.ExpiresAt) {
+		return TokenPair{}, ErrInvalidRefresh
+	}
+	sess, ok := s.repo.SessionByID(t.FamilyID)
+	if !ok || sess.ClientID != clientID {
+		return TokenPair{}, ErrInvalidRefresh
+	}
+	if t.UsedAt != nil {
+		return TokenPair{}, s.reuse(t)
+	}
+	won, err := s.repo.MarkRefreshUsed(t.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !won {
+		// параллельный запрос успел раньше — это тоже повторное предъявление
+		return TokenPair{}, s.reuse(t)
+	}
+	u, ok := s.activeUser(t.UserID)
+	if !ok {
+		return TokenPair{}, ErrInvalidRefresh
+	}
+	pair, err := s.issuePair(u, sess)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if err := s.repo.TouchSession(t.FamilyID, client, time.Now().Add(s.refreshTTL)); err != nil {
+		log.Printf("[auth] touch session %s: %v", t.FamilyID, err)
+	}
+	return pair, nil
+}
+
+func (s *service) Logout(refreshToken string) error {
+	t, ok := s.repo.RefreshByHash(hashToken(refreshToken))
+	if !ok {
+		return ErrInvalidRefresh
+	}
+	return s.endSession(t.FamilyID)
+}
+
+func (s *service) SessionActive(sessionID string) (bool, error) {
+	if sessionID == "" {
+		return false, nil
+	}
+	return s.repo.FamilyActive(sessionID)
+}
+
+// reuse — токен предъявлен повторно: считаем семейство скомпрометированным
+func (s *service) reuse(t RefreshToken) error {
+	log.Printf("[auth] refresh reuse: user=%d family=%s", t.UserID, t.FamilyID)
+	if err := s.endSession(t.FamilyID); err != nil {
+		return err
+	}
+	return ErrRefreshReuse
+}
+
+func (s *service) issuePair(u Manager, sess Session) (TokenPair, error) {
+	access, err := s.issueAccess(u, sess)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh := randomToken(32)
+	if err := s.repo.SaveRefresh(RefreshToken{
+		UserID:    u.ID,
+		FamilyID:  sess.ID,
+		Hash:      hashToken(refresh),
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	}); err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{AccessToken: access, RefreshToken: refresh, ExpiresIn: s.ttl, Role: u.Role, SessionID: sess.ID}, nil
+}
+
+// newFamilyID — id семейства refresh-токенов, он же id сессии
+func newFamilyID() string { return randomToken(16) }
+
+func newTokenID() string { return randomToken(16) }
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRefreshRotation(t *testing.T) {
+	s := newTestService(t)
+	first := login(t, s, "office", "office")
+	second, err := s.Refresh(first.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.SessionID != first.SessionID {
+		t.Fatalf("rotation must keep the session and change the token: %+v", second)
+	}
+	third, err := s.Refresh(second.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.SessionID != first.SessionID {
+		t.Fatal("session changed on rotation")
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	s := newTestService(t)
+	first := login(t, s, "office", "office")
+	second, err := s.Refresh(first.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// повторное предъявление использованного токена — кража: вся сессия отзывается
+	if _, err := s.Refresh(first.RefreshToken, ClientInfo{}); !errors.Is(err, ErrRefreshReuse) {
+		t.Fatalf("reuse: got %v, want ErrRefreshReuse", err)
+	}
+	if _, err := s.Refresh(second.RefreshToken, ClientInfo{}); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("newest token after reuse: got %v, want ErrInvalidRefresh", err)
+	}
+	if ok, _ := s.SessionActive(first.SessionID); ok {
+		t.Fatal("session still active after reuse")
+	}
+	// другие сессии пользователя не затронуты
+	other := login(t, s, "office", "office")
+	if _, err := s.Refresh(other.RefreshToken, ClientInfo{}); err != nil {
+		t.Fatalf("other session: %v", err)
+	}
+}
+
+func TestRefreshInvalid(t *testing.T) {
+	s := newTestService(t)
+	if _, err := s.Refresh("nope", ClientInfo{}); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("unknown token: got %v", err)
+	}
+	pair := login(t, s, "logi", "logi")
+	if err := s.Logout(pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Refresh(pair.RefreshToken, ClientInfo{}); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("after logout: got %v", err)
+	}
+	// refresh-токен приложения не годится для OIDC-клиента и наоборот
+	pair = login(t, s, "logi", "logi")
+	if _, err := s.refresh(pair.RefreshToken, "some-client", ClientInfo{}); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("foreign client: got %v", err)
+	}
+}
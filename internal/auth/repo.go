@@ -1,23 +1,713 @@
 package auth
 
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"template/internal/middleware/authmw"
+)
+
+var (
+	ErrUsernameTaken = errors.New("username already taken")
+	ErrEmailTaken    = errors.New("email already taken")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrUnknownRole   = errors.New("unknown role")
+)
+
 type Repo interface {
+	EnsureSchema() error
 	ByUsername(username string) (Manager, bool)
+	ByEmail(email string) (Manager, bool)
+	Create(m Manager) (Manager, error)
+	UpdatePassword(id int64, hash string) error
+	SetLockedUntil(id int64, until *time.Time) error
+	Count() (int, error)
+	RoleExists(name string) (bool, error)
+	RolePermissions(role string) ([]string, error)
+	ByID(id int64) (Manager, bool)
+	// List — страница пользователей по фильтру (по id) и общее число подходящих
+	List(f UserFilter) ([]Manager, int, error)
+	// UpdateAccount сохраняет email, роль, логточку и флаги Disabled/MustChangePassword
+	UpdateAccount(m Manager) error
+	Delete(id int64) error
+
+	// второй фактор
+	SetTOTP(id int64, secret string, enabled bool) error
+	// UseTOTPStep атомарно запоминает шаг TOTP; false — шаг не новее уже принятого
+	UseTOTPStep(id, step int64) (bool, error)
+	// ReplaceRecoveryCodes заменяет все резервные коды пользователя (хеши)
+	ReplaceRecoveryCodes(userID int64, hashes []string) error
+	// UseRecoveryCode атомарно гасит код; false — нет такого или уже использован
+	UseRecoveryCode(userID int64, hash string) (bool, error)
+
+	// сброс пароля по письму
+	// SaveResetToken сохраняет токен, прежние неиспользованные токены пользователя удаляются
+	SaveResetToken(t PasswordResetToken) error
+	ResetTokenByHash(hash string) (PasswordResetToken, bool)
+	// UseResetToken атомарно гасит токен; false — уже использован
+	UseResetToken(id int64) (bool, error)
+
+	// сессии (вход на устройстве); RevokeFamily и RevokeUserSessions их завершают
+	CreateSession(s Session) error
+	// TouchSession — обновление токенов: last_seen, адрес клиента и срок сессии
+	TouchSession(id string, client ClientInfo, expiresAt time.Time) error
+	SessionByID(id string) (Session, bool)
+	// UserSessions — незавершённые и не истёкшие на момент now, новые первыми
+	UserSessions(userID int64, now time.Time) ([]Session, error)
+
+	// refresh-токены
+	SaveRefresh(t RefreshToken) error
+	RefreshByHash(hash string) (RefreshToken, bool)
+	// MarkRefreshUsed атомарно помечает токен использованным; false — уже был использован
+	MarkRefreshUsed(id int64) (bool, error)
+	RevokeFamily(familyID string) error
+	// RevokeUserSessions отзывает все сессии пользователя, кроме except; возвращает отозванные
+	RevokeUserSessions(userID int64, except string) ([]string, error)
+
+	// организации и ключи интеграций
+	CreateOrganization(name string) (Organization, error)
+	ListOrganizations() ([]Organization, error)
+	OrganizationByID(id int64) (Organization, bool)
+	CreateAPIKey(k APIKey) (APIKey, error)
+	ListAPIKeys(orgID int64) ([]APIKey, error)
+	APIKeyByHash(hash string) (APIKey, bool)
+	// RevokeAPIKey помечает ключ отозванным и возвращает его
+	RevokeAPIKey(id int64) (APIKey, error)
+	// TouchAPIKey обновляет last_used_at (не чаще раза в минуту)
+	TouchAPIKey(id int64) error
+
+	// OpenID Connect
+	CreateOIDCClient(c OIDCClient) (OIDCClient, error)
+	ListOIDCClients() ([]OIDCClient, error)
+	OIDCClientByID(id string) (OIDCClient, bool)
+	DeleteOIDCClient(id string) error
+	SaveAuthCode(c AuthCode) error
+	// UseAuthCode атомарно гасит код и возвращает его; false — нет или уже использован
+	UseAuthCode(hash string) (AuthCode, bool, error)
+
+	// список отзыва access-токенов
+	AddRevocations(rs []RevokedToken) error
+	// Revocations — записи, ещё не истёкшие на момент now
+	Revocations(now time.Time) ([]RevokedToken, error)
+	// FamilyActive — есть ли в семействе неотозванные токены
+	FamilyActive(familyID string) (bool, error)
 }
 
 type memRepo struct {
+	mu    sync.RWMutex
 	users map[string]Manager
+	next  int64
+
+	refresh     map[string]RefreshToken // по хешу
+	nextRefresh int64
+
+	recovery map[int64]map[string]bool     // user_id → хеш → использован
+	resets   map[string]PasswordResetToken // по хешу
+	sessions map[string]Session
+
+	oidcClients map[string]OIDCClient
+	authCodes   map[string]AuthCode // по хешу
+	nextReset   int64
+	revoked     map[string]RevokedToken // kind:value
+
+	orgs    []Organization
+	apiKeys []APIKey
 }
 
+// NewMemRepo — хранилище в памяти, для тестов и локального запуска без БД
 func NewMemRepo() Repo {
+	now := time.Now()
 	return &memRepo{
 		users: map[string]Manager{
-			"office": {ID: 1, Username: "office", Password: "office", Role: "office_manager"},
-			"logi":   {ID: 2, Username: "logi", Password: "logi", Role: "logistics_manager"},
+			"office": {ID: 1, Username: "office", Password: mustHash("office"), Role: authmw.RoleOfficeManager, CreatedAt: now, UpdatedAt: now},
+			"logi":   {ID: 2, Username: "logi", Password: mustHash("logi"), Role: authmw.RoleLogisticsManager, CreatedAt: now, UpdatedAt: now},
 		},
+		next:        3,
+		refresh:     map[string]RefreshToken{},
+		nextRefresh: 1,
+		recovery:    map[int64]map[string]bool{},
+		resets:      map[string]PasswordResetToken{},
+		sessions:    map[string]Session{},
+		oidcClients: map[string]OIDCClient{},
+		authCodes:   map[string]AuthCode{},
+		nextReset:   1,
+		revoked:     map[string]RevokedToken{},
 	}
 }
 
+func mustHash(pwd string) string {
+	b, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func (r *memRepo) EnsureSchema() error { return nil }
+
 func (r *memRepo) ByUsername(username string) (Manager, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	m, ok := r.users[username]
 	return m, ok
 }
+
+func (r *memRepo) ByEmail(email string) (Manager, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, m := range r.users {
+		if m.Email != "" && strings.EqualFold(m.Email, email) {
+			return m, true
+		}
+	}
+	return Manager{}, false
+}
+
+// emailTaken — адрес занят другим пользователем; вызывать под r.mu
+func (r *memRepo) emailTaken(email string, except int64) bool {
+	if email == "" {
+		return false
+	}
+	for _, m := range r.users {
+		if m.ID != except && strings.EqualFold(m.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memRepo) Create(m Manager) (Manager, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[m.Username]; ok {
+		return Manager{}, ErrUsernameTaken
+	}
+	if r.emailTaken(m.Email, 0) {
+		return Manager{}, ErrEmailTaken
+	}
+	now := time.Now()
+	m.ID = r.next
+	m.CreatedAt, m.UpdatedAt = now, now
+	r.next++
+	r.users[m.Username] = m
+	return m, nil
+}
+
+func (r *memRepo) UpdatePassword(id int64, hash string) error {
+	return r.updateUser(id, func(m *Manager) { m.Password = hash })
+}
+
+func (r *memRepo) SetLockedUntil(id int64, until *time.Time) error {
+	return r.updateUser(id, func(m *Manager) { m.LockedUntil = until })
+}
+
+func (r *memRepo) UpdateAccount(u Manager) error {
+	r.mu.RLock()
+	taken := r.emailTaken(u.Email, u.ID)
+	r.mu.RUnlock()
+	if taken {
+		return ErrEmailTaken
+	}
+	return r.updateUser(u.ID, func(m *Manager) {
+		m.Email = u.Email
+		m.Role = u.Role
+		m.LogisticsPointID = u.LogisticsPointID
+		m.Disabled = u.Disabled
+		m.MustChangePassword = u.MustChangePassword
+	})
+}
+
+func (r *memRepo) Delete(id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, m := range r.users {
+		if m.ID == id {
+			delete(r.users, k)
+			delete(r.recovery, id)
+			for h, t := range r.resets {
+				if t.UserID == id {
+					delete(r.resets, h)
+				}
+			}
+			for sid, s := range r.sessions {
+				if s.UserID == id {
+					delete(r.sessions, sid)
+				}
+			}
+			for h, t := range r.refresh {
+				if t.UserID == id {
+					delete(r.refresh, h)
+				}
+			}
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (r *memRepo) List(f UserFilter) ([]Manager, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	q := strings.ToLower(f.Query)
+	var all []Manager
+	for _, m := range r.users {
+		if q != "" && !strings.Contains(m.Username, q) && !strings.Contains(strings.ToLower(m.Email), q) {
+			continue
+		}
+		if f.Role != "" && m.Role != f.Role {
+			continue
+		}
+		if f.Disabled != nil && m.Disabled != *f.Disabled {
+			continue
+		}
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	total := len(all)
+	if f.Offset >= total {
+		return []Manager{}, total, nil
+	}
+	all = all[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(all) {
+		all = all[:f.Limit]
+	}
+	return all, total, nil
+}
+
+func (r *memRepo) SetTOTP(id int64, secret string, enabled bool) error {
+	return r.updateUser(id, func(m *Manager) { m.TOTPSecret, m.TOTPEnabled = secret, enabled })
+}
+
+func (r *memRepo) UseTOTPStep(id, step int64) (bool, error) {
+	won := false
+	err := r.updateUser(id, func(m *Manager) {
+		if m.TOTPLastStep < step {
+			m.TOTPLastStep, won = step, true
+		}
+	})
+	return won, err
+}
+
+func (r *memRepo) ReplaceRecoveryCodes(userID int64, hashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	codes := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		codes[h] = false
+	}
+	r.recovery[userID] = codes
+	return nil
+}
+
+func (r *memRepo) UseRecoveryCode(userID int64, hash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	used, ok := r.recovery[userID][hash]
+	if !ok || used {
+		return false, nil
+	}
+	r.recovery[userID][hash] = true
+	return true, nil
+}
+
+func (r *memRepo) updateUser(id int64, f func(m *Manager)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, m := range r.users {
+		if m.ID == id {
+			f(&m)
+			m.UpdatedAt = time.Now()
+			r.users[k] = m
+			return nil
+		}
+	}
+	return ErrUserNotFound
+}
+
+func (r *memRepo) Count() (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.users), nil
+}
+
+func (r *memRepo) RoleExists(name string) (bool, error) {
+	_, ok := defaultRolePermissions[name]
+	return ok, nil
+}
+
+func (r *memRepo) RolePermissions(role string) ([]string, error) {
+	return defaultRolePermissions[role], nil
+}
+
+func (r *memRepo) ByID(id int64) (Manager, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, m := range r.users {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Manager{}, false
+}
+
+func (r *memRepo) SaveResetToken(t PasswordResetToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for h, old := range r.resets {
+		if old.UserID == t.UserID && old.UsedAt == nil {
+			delete(r.resets, h)
+		}
+	}
+	t.ID = r.nextReset
+	t.CreatedAt = time.Now()
+	r.nextReset++
+	r.resets[t.Hash] = t
+	return nil
+}
+
+func (r *memRepo) ResetTokenByHash(hash string) (PasswordResetToken, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.resets[hash]
+	return t, ok
+}
+
+func (r *memRepo) UseResetToken(id int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, t := range r.resets {
+		if t.ID != id {
+			continue
+		}
+		if t.UsedAt != nil {
+			return false, nil
+		}
+		now := time.Now()
+		t.UsedAt = &now
+		r.resets[k] = t
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *memRepo) CreateSession(s Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[s.ID] = s
+	return nil
+}
+
+func (r *memRepo) TouchSession(id string, client ClientInfo, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[id]
+	if !ok {
+		return nil
+	}
+	s.LastSeenAt, s.ExpiresAt = time.Now(), expiresAt
+	if client.IP != "" {
+		s.IP = client.IP
+	}
+	r.sessions[id] = s
+	return nil
+}
+
+func (r *memRepo) SessionByID(id string) (Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.sessions[id]
+	return s, ok
+}
+
+func (r *memRepo) UserSessions(userID int64, now time.Time) ([]Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := []Session{}
+	for _, s := range r.sessions {
+		if s.UserID == userID && s.EndedAt == nil && now.Before(s.ExpiresAt) {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeenAt.After(out[j].LastSeenAt) })
+	return out, nil
+}
+
+// endSessionLocked — отметить сессию завершённой; вызывать под r.mu
+func (r *memRepo) endSessionLocked(id string, now time.Time) {
+	if s, ok := r.sessions[id]; ok && s.EndedAt == nil {
+		s.EndedAt = &now
+		r.sessions[id] = s
+	}
+}
+
+func (r *memRepo) SaveRefresh(t RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t.ID = r.nextRefresh
+	t.CreatedAt = time.Now()
+	r.nextRefresh++
+	r.refresh[t.Hash] = t
+	return nil
+}
+
+func (r *memRepo) RefreshByHash(hash string) (RefreshToken, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.refresh[hash]
+	return t, ok
+}
+
+func (r *memRepo) MarkRefreshUsed(id int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, t := range r.refresh {
+		if t.ID != id {
+			continue
+		}
+		if t.UsedAt != nil {
+			return false, nil
+		}
+		now := time.Now()
+		t.UsedAt = &now
+		r.refresh[k] = t
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *memRepo) RevokeFamily(familyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for k, t := range r.refresh {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+			r.refresh[k] = t
+		}
+	}
+	r.endSessionLocked(familyID, now)
+	return nil
+}
+
+func (r *memRepo) RevokeUserSessions(userID int64, except string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	seen := map[string]bool{}
+	var ids []string
+	for k, t := range r.refresh {
+		if t.UserID == userID && t.FamilyID != except && t.RevokedAt == nil {
+			t.RevokedAt = &now
+			r.refresh[k] = t
+			if !seen[t.FamilyID] {
+				seen[t.FamilyID] = true
+				ids = append(ids, t.FamilyID)
+			}
+		}
+	}
+	for id, s := range r.sessions {
+		if s.UserID == userID && id != except {
+			r.endSessionLocked(id, now)
+		}
+	}
+	return ids, nil
+}
+
+func (r *memRepo) AddRevocations(rs []RevokedToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for k, v := range r.revoked {
+		if !v.ExpiresAt.After(now) {
+			delete(r.revoked, k)
+		}
+	}
+	for _, v := range rs {
+		key := v.Kind + ":" + v.Value
+		if old, ok := r.revoked[key]; ok && old.ExpiresAt.After(v.ExpiresAt) {
+			continue
+		}
+		r.revoked[key] = v
+	}
+	return nil
+}
+
+func (r *memRepo) Revocations(now time.Time) ([]RevokedToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]RevokedToken, 0, len(r.revoked))
+	for _, v := range r.revoked {
+		if v.ExpiresAt.After(now) {
+			out = append(out, v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind+out[i].Value < out[j].Kind+out[j].Value })
+	return out, nil
+}
+
+func (r *memRepo) FamilyActive(familyID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, t := range r.refresh {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memRepo) CreateOrganization(name string) (Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o := Organization{ID: int64(len(r.orgs) + 1), Name: name, CreatedAt: time.Now()}
+	r.orgs = append(r.orgs, o)
+	return o, nil
+}
+
+func (r *memRepo) ListOrganizations() ([]Organization, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.orgs), nil
+}
+
+func (r *memRepo) OrganizationByID(id int64) (Organization, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, o := range r.orgs {
+		if o.ID == id {
+			return o, true
+		}
+	}
+	return Organization{}, false
+}
+
+func (r *memRepo) CreateAPIKey(k APIKey) (APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k.ID = int64(len(r.apiKeys) + 1)
+	k.CreatedAt = time.Now()
+	r.apiKeys = append(r.apiKeys, k)
+	return k, nil
+}
+
+func (r *memRepo) ListAPIKeys(orgID int64) ([]APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := []APIKey{}
+	for _, k := range r.apiKeys {
+		if k.OrganizationID == orgID {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func (r *memRepo) APIKeyByHash(hash string) (APIKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, k := range r.apiKeys {
+		if k.Hash == hash {
+			return k, true
+		}
+	}
+	return APIKey{}, false
+}
+
+func (r *memRepo) RevokeAPIKey(id int64) (APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, k := range r.apiKeys {
+		if k.ID == id {
+			if k.RevokedAt == nil {
+				now := time.Now()
+				r.apiKeys[i].RevokedAt = &now
+			}
+			return r.apiKeys[i], nil
+		}
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+func (r *memRepo) TouchAPIKey(id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, k := range r.apiKeys {
+		if k.ID == id {
+			now := time.Now()
+			r.apiKeys[i].LastUsedAt = &now
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+func (r *memRepo) CreateOIDCClient(c OIDCClient) (OIDCClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.oidcClients[c.ID]; ok {
+		return OIDCClient{}, ErrOIDCClientExists
+	}
+	c.CreatedAt = time.Now()
+	r.oidcClients[c.ID] = c
+	return c, nil
+}
+
+func (r *memRepo) ListOIDCClients() ([]OIDCClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]OIDCClient, 0, len(r.oidcClients))
+	for _, c := range r.oidcClients {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (r *memRepo) OIDCClientByID(id string) (OIDCClient, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.oidcClients[id]
+	return c, ok
+}
+
+func (r *memRepo) DeleteOIDCClient(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.oidcClients[id]; !ok {
+		return ErrOIDCClientNotFound
+	}
+	delete(r.oidcClients, id)
+	return nil
+}
+
+func (r *memRepo) SaveAuthCode(c AuthCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for h, old := range r.authCodes {
+		if now.After(old.ExpiresAt) {
+			delete(r.authCodes, h)
+		}
+	}
+	r.authCodes[c.Hash] = c
+	return nil
+}
+
+func (r *memRepo) UseAuthCode(hash string) (AuthCode, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.authCodes[hash]
+	if !ok || c.UsedAt != nil {
+		return AuthCode{}, false, nil
+	}
+	now := time.Now()
+	c.UsedAt = &now
+	r.authCodes[hash] = c
+	return c, true, nil
+}
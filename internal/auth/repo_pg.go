@@ -0,0 +1,724 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"template/internal/middleware/authmw"
+)
+
+type pgRepo struct{ db *sql.DB }
+
+func NewPgRepo(db *sql.DB) Repo { return &pgRepo{db: db} }
+
+func (r *pgRepo) EnsureSchema() error {
+	if err := r.ensureTables(); err != nil {
+		return err
+	}
+	return r.seedPermissions()
+}
+
+func (r *pgRepo) ensureTables() error {
+	ddl := `
+CREATE TABLE IF NOT EXISTS roles (
+  name TEXT PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT ''
+);
+INSERT INTO roles(name, description) VALUES
+  ('admin', 'администратор'),
+  ('office_manager', 'менеджер офиса'),
+  ('logistics_manager', 'менеджер логистической точки'),
+  ('service', 'сервис (client credentials)')
+ON CONFLICT (name) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS permissions (
+  name TEXT PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+  permission TEXT NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+  PRIMARY KEY (role, permission)
+);
+-- пары из defaultRolePermissions, которые уже выдавались: повторно не выдаются,
+-- так что снятое администратором разрешение не вернётся при перезапуске
+CREATE TABLE IF NOT EXISTS role_permission_seeds (
+  role TEXT NOT NULL,
+  permission TEXT NOT NULL,
+  seeded_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+  id BIGSERIAL PRIMARY KEY,
+  username TEXT NOT NULL,
+  password TEXT NOT NULL, -- bcrypt-хеш
+  role TEXT NOT NULL REFERENCES roles(name),
+  locked_until TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ux_users_username ON users(LOWER(username));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS logistics_point_id BIGINT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS ux_users_email ON users(LOWER(email)) WHERE email <> '';
+
+-- токены сброса пароля из писем: только SHA-256, одноразовые
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash TEXT NOT NULL UNIQUE,
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_password_reset_user ON password_reset_tokens(user_id);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash TEXT NOT NULL,
+  used_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  family_id TEXT NOT NULL,
+  token_hash TEXT NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP,
+  revoked_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ux_refresh_hash ON refresh_tokens(token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_family ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_user ON refresh_tokens(user_id);
+
+-- сессии: id = family_id refresh-токенов = sid в access-токенах
+CREATE TABLE IF NOT EXISTS sessions (
+  id TEXT PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  ip TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  device TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  ended_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id) WHERE ended_at IS NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS client_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS organizations (
+  id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- ключи интеграций: только SHA-256 от ключа
+CREATE TABLE IF NOT EXISTS api_keys (
+  id BIGSERIAL PRIMARY KEY,
+  organization_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  prefix TEXT NOT NULL,
+  key_hash TEXT NOT NULL UNIQUE,
+  permissions TEXT[] NOT NULL,
+  rate_limit INTEGER NOT NULL DEFAULT 0,
+  created_by BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_org ON api_keys(organization_id);
+
+-- OpenID Connect: зарегистрированные приложения и коды авторизации (SHA-256)
+CREATE TABLE IF NOT EXISTS oidc_clients (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  secret_hash TEXT NOT NULL DEFAULT '',
+  redirect_uris TEXT[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS oidc_codes (
+  code_hash TEXT PRIMARY KEY,
+  client_id TEXT NOT NULL REFERENCES oidc_clients(id) ON DELETE CASCADE,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  redirect_uri TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  nonce TEXT NOT NULL DEFAULT '',
+  code_challenge TEXT NOT NULL DEFAULT '',
+  auth_time TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ
+);
+
+-- отозванные access-токены (jti) и сессии (sid) до истечения их срока
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  kind TEXT NOT NULL,
+  value TEXT NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (kind, value)
+);
+`
+	_, err := r.db.Exec(ddl)
+	return err
+}
+
+// seedPermissions — каталог разрешений всегда актуален; каждая пара из
+// defaultRolePermissions выдаётся роли один раз (role_permission_seeds), так что
+// новые разрешения по умолчанию доходят и до уже засеянных БД
+func (r *pgRepo) seedPermissions() error {
+	for _, p := range authmw.Permissions {
+		if _, err := r.db.Exec(`INSERT INTO permissions(name) VALUES($1) ON CONFLICT DO NOTHING`, p); err != nil {
+			return err
+		}
+	}
+	for role, perms := range defaultRolePermissions {
+		if _, err := r.db.Exec(`
+WITH fresh AS (
+  INSERT INTO role_permission_seeds(role, permission)
+  SELECT $1, p FROM unnest($2::TEXT[]) AS p
+  ON CONFLICT DO NOTHING
+  RETURNING role, permission
+)
+INSERT INTO role_permissions(role, permission) SELECT role, permission FROM fresh
+ON CONFLICT DO NOTHING`, role, pq.Array(perms)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const userColumns = `id,username,email,password,role,logistics_point_id,disabled,must_change_password,locked_until,totp_secret,totp_enabled,totp_last_step,created_at,updated_at`
+
+type rowScanner interface{ Scan(dest ...any) error }
+
+func scanUser(row rowScanner) (Manager, error) {
+	var m Manager
+	err := row.Scan(&m.ID, &m.Username, &m.Email, &m.Password, &m.Role, &m.LogisticsPointID, &m.Disabled, &m.MustChangePassword, &m.LockedUntil, &m.TOTPSecret, &m.TOTPEnabled, &m.TOTPLastStep, &m.CreatedAt, &m.UpdatedAt)
+	return m, err
+}
+
+func (r *pgRepo) ByUsername(username string) (Manager, bool) {
+	m, err := scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE LOWER(username)=LOWER($1)`, username))
+	return m, err == nil
+}
+
+func (r *pgRepo) ByEmail(email string) (Manager, bool) {
+	m, err := scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE email <> '' AND LOWER(email)=LOWER($1)`, email))
+	return m, err == nil
+}
+
+func (r *pgRepo) Create(m Manager) (Manager, error) {
+	out, err := scanUser(r.db.QueryRow(`INSERT INTO users(username,email,password,role,logistics_point_id,must_change_password)
+VALUES($1,$2,$3,$4,$5,$6) RETURNING `+userColumns, m.Username, m.Email, m.Password, m.Role, m.LogisticsPointID, m.MustChangePassword))
+	if err := uniqueErr(err); err != nil {
+		return Manager{}, err
+	}
+	return out, err
+}
+
+// uniqueErr — нарушение уникальности логина или email в ошибку сервиса
+func uniqueErr(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return nil
+	}
+	if pqErr.Constraint == "ux_users_email" {
+		return ErrEmailTaken
+	}
+	return ErrUsernameTaken
+}
+
+func (r *pgRepo) UpdatePassword(id int64, hash string) error {
+	return r.execUser(`UPDATE users SET password=$1, updated_at=NOW() WHERE id=$2`, hash, id)
+}
+
+func (r *pgRepo) SetLockedUntil(id int64, until *time.Time) error {
+	return r.execUser(`UPDATE users SET locked_until=$1, updated_at=NOW() WHERE id=$2`, until, id)
+}
+
+func (r *pgRepo) UpdateAccount(m Manager) error {
+	err := r.execUser(`UPDATE users SET email=$1, role=$2, logistics_point_id=$3, disabled=$4, must_change_password=$5, updated_at=NOW()
+WHERE id=$6`, m.Email, m.Role, m.LogisticsPointID, m.Disabled, m.MustChangePassword, m.ID)
+	if uerr := uniqueErr(err); uerr != nil {
+		return uerr
+	}
+	return err
+}
+
+func (r *pgRepo) Delete(id int64) error {
+	return r.execUser(`DELETE FROM users WHERE id=$1`, id)
+}
+
+func (r *pgRepo) List(f UserFilter) ([]Manager, int, error) {
+	where, args := []string{"TRUE"}, []any{}
+	if f.Query != "" {
+		args = append(args, "%"+likeEscape(strings.ToLower(f.Query))+"%")
+		where = append(where, fmt.Sprintf("(LOWER(username) LIKE $%[1]d OR LOWER(email) LIKE $%[1]d)", len(args)))
+	}
+	if f.Role != "" {
+		args = append(args, f.Role)
+		where = append(where, fmt.Sprintf("role=$%d", len(args)))
+	}
+	if f.Disabled != nil {
+		args = append(args, *f.Disabled)
+		where = append(where, fmt.Sprintf("disabled=$%d", len(args)))
+	}
+	cond := strings.Join(where, " AND ")
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM users WHERE `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, f.Limit, f.Offset)
+	rows, err := r.db.Query(fmt.Sprintf(`SELECT `+userColumns+` FROM users WHERE %s ORDER BY id LIMIT $%d OFFSET $%d`,
+		cond, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	out := []Manager{}
+	for rows.Next() {
+		m, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, m)
+	}
+	return out, total, rows.Err()
+}
+
+// likeEscape экранирует спецсимволы LIKE в пользовательском вводе
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (r *pgRepo) SetTOTP(id int64, secret string, enabled bool) error {
+	return r.execUser(`UPDATE users SET totp_secret=$1, totp_enabled=$2, updated_at=NOW() WHERE id=$3`, secret, enabled, id)
+}
+
+func (r *pgRepo) UseTOTPStep(id, step int64) (bool, error) {
+	res, err := r.db.Exec(`UPDATE users SET totp_last_step=$1 WHERE id=$2 AND totp_last_step < $1`, step, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (r *pgRepo) ReplaceRecoveryCodes(userID int64, hashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes(user_id,code_hash) VALUES($1,$2)`, userID, h); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *pgRepo) UseRecoveryCode(userID int64, hash string) (bool, error) {
+	res, err := r.db.Exec(`UPDATE recovery_codes SET used_at=NOW() WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`, userID, hash)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// execUser — UPDATE одного пользователя; ErrUserNotFound, если строк не задето
+func (r *pgRepo) execUser(q string, args ...any) error {
+	res, err := r.db.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *pgRepo) Count() (int, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n)
+	return n, err
+}
+
+func (r *pgRepo) RoleExists(name string) (bool, error) {
+	var ok bool
+	err := r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM roles WHERE name=$1)`, name).Scan(&ok)
+	return ok, err
+}
+
+func (r *pgRepo) ByID(id int64) (Manager, bool) {
+	m, err := scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id=$1`, id))
+	return m, err == nil
+}
+
+func (r *pgRepo) SaveResetToken(t PasswordResetToken) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// заодно чистим истёкшие токены всех пользователей
+	if _, err := tx.Exec(`DELETE FROM password_reset_tokens WHERE (user_id=$1 AND used_at IS NULL) OR expires_at < NOW()`, t.UserID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO password_reset_tokens(user_id,token_hash,expires_at) VALUES($1,$2,$3)`,
+		t.UserID, t.Hash, t.ExpiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *pgRepo) ResetTokenByHash(hash string) (PasswordResetToken, bool) {
+	row := r.db.QueryRow(`SELECT id,user_id,token_hash,expires_at,used_at,created_at
+FROM password_reset_tokens WHERE token_hash=$1`, hash)
+	var t PasswordResetToken
+	if err := row.Scan(&t.ID, &t.UserID, &t.Hash, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt); err != nil {
+		return PasswordResetToken{}, false
+	}
+	return t, true
+}
+
+func (r *pgRepo) UseResetToken(id int64) (bool, error) {
+	res, err := r.db.Exec(`UPDATE password_reset_tokens SET used_at=NOW() WHERE id=$1 AND used_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+const sessionColumns = `id,user_id,ip,user_agent,device,created_at,last_seen_at,expires_at,ended_at,client_id,scope`
+
+func scanSession(row rowScanner) (Session, error) {
+	var s Session
+	err := row.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.Device, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.EndedAt, &s.ClientID, &s.Scope)
+	return s, err
+}
+
+func (r *pgRepo) CreateSession(s Session) error {
+	_, err := r.db.Exec(`INSERT INTO sessions(id,user_id,ip,user_agent,device,expires_at,client_id,scope) VALUES($1,$2,$3,$4,$5,$6,$7,$8)`,
+		s.ID, s.UserID, s.IP, s.UserAgent, s.Device, s.ExpiresAt, s.ClientID, s.Scope)
+	return err
+}
+
+func (r *pgRepo) TouchSession(id string, client ClientInfo, expiresAt time.Time) error {
+	_, err := r.db.Exec(`UPDATE sessions SET last_seen_at=NOW(), expires_at=$2, ip=COALESCE(NULLIF($3,''), ip)
+WHERE id=$1 AND ended_at IS NULL`, id, expiresAt, client.IP)
+	return err
+}
+
+func (r *pgRepo) SessionByID(id string) (Session, bool) {
+	s, err := scanSession(r.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id=$1`, id))
+	return s, err == nil
+}
+
+func (r *pgRepo) UserSessions(userID int64, now time.Time) ([]Session, error) {
+	rows, err := r.db.Query(`SELECT `+sessionColumns+` FROM sessions
+WHERE user_id=$1 AND ended_at IS NULL AND expires_at > $2 ORDER BY last_seen_at DESC`, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func (r *pgRepo) SaveRefresh(t RefreshToken) error {
+	_, err := r.db.Exec(`INSERT INTO refresh_tokens(user_id,family_id,token_hash,expires_at) VALUES($1,$2,$3,$4)`,
+		t.UserID, t.FamilyID, t.Hash, t.ExpiresAt)
+	return err
+}
+
+func (r *pgRepo) RefreshByHash(hash string) (RefreshToken, bool) {
+	row := r.db.QueryRow(`SELECT id,user_id,family_id,token_hash,expires_at,used_at,revoked_at,created_at
+FROM refresh_tokens WHERE token_hash=$1`, hash)
+	var t RefreshToken
+	if err := row.Scan(&t.ID, &t.UserID, &t.FamilyID, &t.Hash, &t.ExpiresAt, &t.UsedAt, &t.RevokedAt, &t.CreatedAt); err != nil {
+		return RefreshToken{}, false
+	}
+	return t, true
+}
+
+func (r *pgRepo) MarkRefreshUsed(id int64) (bool, error) {
+	res, err := r.db.Exec(`UPDATE refresh_tokens SET used_at=NOW() WHERE id=$1 AND used_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (r *pgRepo) RevokeFamily(familyID string) error {
+	_, err := r.db.Exec(`WITH s AS (
+  UPDATE sessions SET ended_at=NOW() WHERE id=$1 AND ended_at IS NULL
+) UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=$1 AND revoked_at IS NULL`, familyID)
+	return err
+}
+
+func (r *pgRepo) RevokeUserSessions(userID int64, except string) ([]string, error) {
+	rows, err := r.db.Query(`WITH s AS (
+  UPDATE sessions SET ended_at=NOW() WHERE user_id=$1 AND id<>$2 AND ended_at IS NULL
+), t AS (
+  UPDATE refresh_tokens SET revoked_at=NOW() WHERE user_id=$1 AND family_id<>$2 AND revoked_at IS NULL RETURNING family_id
+) SELECT DISTINCT family_id FROM t`, userID, except)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AddRevocations заодно чистит истёкшие записи — список не растёт
+func (r *pgRepo) AddRevocations(rs []RevokedToken) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM revoked_tokens WHERE expires_at <= NOW()`); err != nil {
+		return err
+	}
+	for _, v := range rs {
+		if _, err := tx.Exec(`INSERT INTO revoked_tokens(kind,value,expires_at) VALUES($1,$2,$3)
+ON CONFLICT (kind,value) DO UPDATE SET expires_at=GREATEST(revoked_tokens.expires_at, EXCLUDED.expires_at)`,
+			v.Kind, v.Value, v.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *pgRepo) Revocations(now time.Time) ([]RevokedToken, error) {
+	rows, err := r.db.Query(`SELECT kind,value,expires_at FROM revoked_tokens WHERE expires_at > $1 ORDER BY kind,value`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []RevokedToken
+	for rows.Next() {
+		var v RevokedToken
+		if err := rows.Scan(&v.Kind, &v.Value, &v.ExpiresAt); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func (r *pgRepo) FamilyActive(familyID string) (bool, error) {
+	var ok bool
+	err := r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM refresh_tokens WHERE family_id=$1 AND revoked_at IS NULL)`, familyID).Scan(&ok)
+	return ok, err
+}
+
+func (r *pgRepo) RolePermissions(role string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT permission FROM role_permissions WHERE role=$1 ORDER BY permission`, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (r *pgRepo) CreateOrganization(name string) (Organization, error) {
+	var o Organization
+	err := r.db.QueryRow(`INSERT INTO organizations(name) VALUES($1) RETURNING id,name,created_at`, name).
+		Scan(&o.ID, &o.Name, &o.CreatedAt)
+	return o, err
+}
+
+func (r *pgRepo) ListOrganizations() ([]Organization, error) {
+	rows, err := r.db.Query(`SELECT id,name,created_at FROM organizations ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Organization{}
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(&o.ID, &o.Name, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+func (r *pgRepo) OrganizationByID(id int64) (Organization, bool) {
+	var o Organization
+	err := r.db.QueryRow(`SELECT id,name,created_at FROM organizations WHERE id=$1`, id).Scan(&o.ID, &o.Name, &o.CreatedAt)
+	return o, err == nil
+}
+
+const apiKeyColumns = `id,organization_id,name,prefix,key_hash,permissions,rate_limit,created_by,created_at,expires_at,last_used_at,revoked_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.OrganizationID, &k.Name, &k.Prefix, &k.Hash, pq.Array(&k.Permissions), &k.RateLimit,
+		&k.CreatedBy, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+func (r *pgRepo) CreateAPIKey(k APIKey) (APIKey, error) {
+	return scanAPIKey(r.db.QueryRow(`INSERT INTO api_keys(organization_id,name,prefix,key_hash,permissions,rate_limit,created_by,expires_at)
+VALUES($1,$2,$3,$4,$5,$6,$7,$8) RETURNING `+apiKeyColumns,
+		k.OrganizationID, k.Name, k.Prefix, k.Hash, pq.Array(k.Permissions), k.RateLimit, k.CreatedBy, k.ExpiresAt))
+}
+
+func (r *pgRepo) ListAPIKeys(orgID int64) ([]APIKey, error) {
+	rows, err := r.db.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE organization_id=$1 ORDER BY id`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func (r *pgRepo) APIKeyByHash(hash string) (APIKey, bool) {
+	k, err := scanAPIKey(r.db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash=$1`, hash))
+	return k, err == nil
+}
+
+func (r *pgRepo) RevokeAPIKey(id int64) (APIKey, error) {
+	k, err := scanAPIKey(r.db.QueryRow(`UPDATE api_keys SET revoked_at=COALESCE(revoked_at, NOW()) WHERE id=$1 RETURNING `+apiKeyColumns, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return k, err
+}
+
+func (r *pgRepo) TouchAPIKey(id int64) error {
+	_, err := r.db.Exec(`UPDATE api_keys SET last_used_at=NOW()
+WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, id)
+	return err
+}
+
+func (r *pgRepo) CreateOIDCClient(c OIDCClient) (OIDCClient, error) {
+	err := r.db.QueryRow(`INSERT INTO oidc_clients(id,name,secret_hash,redirect_uris) VALUES($1,$2,$3,$4) RETURNING created_at`,
+		c.ID, c.Name, c.SecretHash, pq.Array(c.RedirectURIs)).Scan(&c.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return OIDCClient{}, ErrOIDCClientExists
+	}
+	return c, err
+}
+
+const oidcClientColumns = `id,name,secret_hash,redirect_uris,created_at`
+
+func scanOIDCClient(row rowScanner) (OIDCClient, error) {
+	var c OIDCClient
+	err := row.Scan(&c.ID, &c.Name, &c.SecretHash, pq.Array(&c.RedirectURIs), &c.CreatedAt)
+	return c, err
+}
+
+func (r *pgRepo) ListOIDCClients() ([]OIDCClient, error) {
+	rows, err := r.db.Query(`SELECT ` + oidcClientColumns + ` FROM oidc_clients ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []OIDCClient{}
+	for rows.Next() {
+		c, err := scanOIDCClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (r *pgRepo) OIDCClientByID(id string) (OIDCClient, bool) {
+	c, err := scanOIDCClient(r.db.QueryRow(`SELECT `+oidcClientColumns+` FROM oidc_clients WHERE id=$1`, id))
+	return c, err == nil
+}
+
+func (r *pgRepo) DeleteOIDCClient(id string) error {
+	res, err := r.db.Exec(`DELETE FROM oidc_clients WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrOIDCClientNotFound
+	}
+	return nil
+}
+
+// SaveAuthCode заодно удаляет истёкшие коды
+func (r *pgRepo) SaveAuthCode(c AuthCode) error {
+	if _, err := r.db.Exec(`DELETE FROM oidc_codes WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`INSERT INTO oidc_codes(code_hash,client_id,user_id,redirect_uri,scope,nonce,code_challenge,auth_time,expires_at)
+VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		c.Hash, c.ClientID, c.UserID, c.RedirectURI, c.Scope, c.Nonce, c.CodeChallenge, c.AuthTime, c.ExpiresAt)
+	return err
+}
+
+func (r *pgRepo) UseAuthCode(hash string) (AuthCode, bool, error) {
+	var c AuthCode
+	err := r.db.QueryRow(`UPDATE oidc_codes SET used_at=NOW() WHERE code_hash=$1 AND used_at IS NULL
+RETURNING code_hash,client_id,user_id,redirect_uri,scope,nonce,code_challenge,auth_time,expires_at,used_at`, hash).
+		Scan(&c.Hash, &c.ClientID, &c.UserID, &c.RedirectURI, &c.Scope, &c.Nonce, &c.CodeChallenge, &c.AuthTime, &c.ExpiresAt, &c.UsedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AuthCode{}, false, nil
+	}
+	return c, err == nil, err
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"template/internal/mail"
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+	ErrResetDisabled     = errors.New("password reset disabled")
+)
+
+// PasswordReset — восстановление пароля по письму
+type PasswordReset struct {
+	Mailer mail.Mailer // nil — восстановление выключено
+	// URL — страница сброса, к ней добавляется ?token=...; пусто — в письме только токен
+	URL string
+	TTL time.Duration
+}
+
+// ForgotPassword — письмо со ссылкой сброса пароля. Результат для
+// существующего и несуществующего пользователя одинаков; ошибка — только
+// ThrottleError (слишком часто с этого IP) или выключенное восстановление.
+// Поиск пользователя, запись токена и отправка идут в фоне, так что и время
+// ответа не зависит от того, есть ли такой пользователь
+func (s *service) ForgotPassword(login string, client ClientInfo) error {
+	if s.reset.Mailer == nil {
+		return ErrResetDisabled
+	}
+	now := time.Now()
+	if d := s.limiter.wait("reset-ip:"+client.IP, now); d > 0 {
+		return &ThrottleError{Err: ErrTooManyAttempts, RetryAfter: d}
+	}
+	s.limiter.fail("reset-ip:"+client.IP, now)
+	go s.sendReset(login, client, now)
+	return nil
+}
+
+func (s *service) sendReset(login string, client ClientInfo, now time.Time) {
+	login = strings.ToLower(strings.TrimSpace(login))
+	u, ok := s.repo.ByUsername(login)
+	if !ok && strings.Contains(login, "@") {
+		u, ok = s.repo.ByEmail(login)
+	}
+	switch {
+	case !ok:
+		s.emit(client, "auth.password_reset_requested", login, http.StatusAccepted, "unknown user ip="+client.IP)
+		return
+	case u.Disabled || u.Email == "":
+		s.emit(client, "auth.password_reset_requested", u.Username, http.StatusAccepted, "not sent: disabled or no email ip="+client.IP)
+		return
+	}
+	// не чаще одного письма на пользователя за паузу лимитера
+	if d := s.limiter.wait("reset:"+u.Username, now); d > 0 {
+		s.emit(client, "auth.password_reset_requested", u.Username, http.StatusAccepted, "not sent: throttled ip="+client.IP)
+		return
+	}
+	s.limiter.fail("reset:"+u.Username, now)
+
+	tok := randomToken(32)
+	if err := s.repo.SaveResetToken(PasswordResetToken{
+		UserID:    u.ID,
+		Hash:      hashToken(tok),
+		ExpiresAt: now.Add(s.reset.TTL),
+	}); err != nil {
+		log.Printf("[auth] reset token for %q: %v", u.Username, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.reset.Mailer.Send(ctx, resetMessage(u, tok, s.reset)); err != nil {
+		log.Printf("[auth] reset mail to %q: %v", u.Username, err)
+		return
+	}
+	s.emit(client, "auth.password_reset_requested", u.Username, http.StatusAccepted, "sent ip="+client.IP)
+}
+
+func resetMessage(u Manager, token string, cfg PasswordReset) mail.Message {
+	link := token
+	if cfg.URL != "" {
+		link = cfg.URL + "?token=" + url.QueryEscape(token)
+	}
+	return mail.Message{
+		To:      u.Email,
+		Subject: "Восстановление пароля",
+		Body: fmt.Sprintf("Здравствуйте, %s!\n\nДля учётной записи запрошен сброс пароля. Чтобы задать новый пароль, перейдите по ссылке:\n\n%s\n\n"+
+			"Ссылка одноразовая и действует %d мин. Если вы не запрашивали сброс, просто проигнорируйте письмо.\n",
+			u.Username, link, int(cfg.TTL.Minutes())),
+	}
+}
+
+// ResetPasswordByToken — новый пароль по токену из письма. Токен гасится,
+// блокировка после перебора снимается, все сессии пользователя отзываются.
+func (s *service) ResetPasswordByToken(token, password string, client ClientInfo) error {
+	t, ok := s.repo.ResetTokenByHash(hashToken(token))
+	if !ok || t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+		s.emit(client, "auth.password_reset_failed", "", http.StatusBadRequest, "invalid token")
+		return ErrInvalidResetToken
+	}
+	u, ok := s.activeUser(t.UserID)
+	if !ok {
+		s.emit(client, "auth.password_reset_failed", u.Username, http.StatusBadRequest, "user disabled")
+		return ErrInvalidResetToken
+	}
+	// политику проверяем до того, как погасить токен: слабый пароль можно исправить
+	if err := s.policy.Validate(u.Username, password); err != nil {
+		return err
+	}
+	if same, _ := s.hasher.Verify(u.Password, password); same {
+		return ErrPasswordReused
+	}
+	if used, err := s.repo.UseResetToken(t.ID); err != nil {
+		return err
+	} else if !used {
+		s.emit(client, "auth.password_reset_failed", u.Username, http.StatusBadRequest, "token reused")
+		return ErrInvalidResetToken
+	}
+	if err := s.setPassword(u, password, false); err != nil {
+		return err
+	}
+	if u.LockedUntil != nil {
+		if err := s.repo.SetLockedUntil(u.ID, nil); err != nil {
+			return err
+		}
+	}
+	s.limiter.reset("user:" + u.Username)
+	if err := s.endUserSessions(u.ID, ""); err != nil {
+		return err
+	}
+	s.emit(client, "auth.password_reset", u.Username, http.StatusOK, "by email token")
+	return nil
+}
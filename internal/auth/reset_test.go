@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"template/internal/mail"
+)
+
+var resetLink = regexp.MustCompile(`\?token=([A-Za-z0-9_-]+)`)
+
+// waitResetToken — письмо уходит в фоне; ждём его в файле
+func waitResetToken(t *testing.T, path string) string {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		raw, _ := os.ReadFile(path)
+		if m := resetLink.FindSubmatch(raw); m != nil {
+			return string(m[1])
+		}
+	}
+	t.Fatal("reset mail not written")
+	return ""
+}
+
+func TestPasswordResetByMail(t *testing.T) {
+	s := newTestService(t)
+	path := filepath.Join(t.TempDir(), "mail.eml")
+	s.reset = PasswordReset{Mailer: mail.NewFile(path, "a7@example.com"), URL: "https://a7.example/reset", TTL: time.Hour}
+	if _, err := s.CreateUser(NewUser{Username: "bob", Email: "bob@example.com", Password: "old-password-1", Role: "office_manager"}); err != nil {
+		t.Fatal(err)
+	}
+	other := login(t, s, "bob", "old-password-1")
+
+	client := ClientInfo{IP: "192.0.2.7"}
+	if err := s.ForgotPassword("BOB@example.com", client); err != nil {
+		t.Fatal(err)
+	}
+	tok := waitResetToken(t, path)
+
+	if err := s.ResetPasswordByToken(tok, "new-password-2", client); err != nil {
+		t.Fatal(err)
+	}
+	login(t, s, "bob", "new-password-2")
+	if _, err := s.Login("bob", "old-password-1", client); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("old password: got %v", err)
+	}
+	if ok, _ := s.SessionActive(other.SessionID); ok {
+		t.Fatal("sessions survive a password reset")
+	}
+	if err := s.ResetPasswordByToken(tok, "new-password-3", client); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("token reuse: got %v", err)
+	}
+}
+
+func TestForgotPasswordDisabled(t *testing.T) {
+	s := newTestService(t)
+	if err := s.ForgotPassword("office", ClientInfo{IP: "192.0.2.7"}); !errors.Is(err, ErrResetDisabled) {
+		t.Fatalf("got %v, want ErrResetDisabled", err)
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"template/internal/middleware/authmw"
+)
+
+const (
+	revokedJTI     = "jti"
+	revokedSession = "sid"
+)
+
+// RevokedToken — запись списка отзыва; хранится, пока не истекут сами токены
+type RevokedToken struct {
+	Kind      string // revokedJTI | revokedSession
+	Value     string
+	ExpiresAt time.Time
+}
+
+// endSession — отзыв сессии: и refresh-токенов, и выданных по ним access-токенов
+func (s *service) endSession(familyID string) error {
+	if err := s.repo.RevokeFamily(familyID); err != nil {
+		return err
+	}
+	return s.revokeSessions([]string{familyID})
+}
+
+// endUserSessions — то же для всех сессий пользователя, кроме except
+func (s *service) endUserSessions(userID int64, except string) error {
+	ids, err := s.repo.RevokeUserSessions(userID, except)
+	if err != nil {
+		return err
+	}
+	return s.revokeSessions(ids)
+}
+
+func (s *service) revokeSessions(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	// access-токены сессии, выданные до отзыва, живут не дольше ttl
+	exp := time.Now().Add(s.ttl)
+	rs := make([]RevokedToken, len(ids))
+	for i, id := range ids {
+		rs[i] = RevokedToken{Kind: revokedSession, Value: id, ExpiresAt: exp}
+	}
+	return s.repo.AddRevocations(rs)
+}
+
+// RevokeAccess — отзыв одного access-токена (по jti) до его exp
+func (s *service) RevokeAccess(jti string, exp time.Time) error {
+	if jti == "" || !exp.After(time.Now()) {
+		return nil
+	}
+	return s.repo.AddRevocations([]RevokedToken{{Kind: revokedJTI, Value: jti, ExpiresAt: exp}})
+}
+
+func (s *service) RevocationList() (authmw.RevocationList, error) {
+	rs, err := s.repo.Revocations(time.Now())
+	if err != nil {
+		return authmw.RevocationList{}, err
+	}
+	l := authmw.RevocationList{JTIs: []string{}, Sessions: []string{}}
+	for _, r := range rs {
+		switch r.Kind {
+		case revokedJTI:
+			l.JTIs = append(l.JTIs, r.Value)
+		case revokedSession:
+			l.Sessions = append(l.Sessions, r.Value)
+		}
+	}
+	return l, nil
+}
+
+// localRevocations — список отзыва для собственного MW auth: из своей БД,
+// без HTTP (другие экземпляры auth пишут в ту же БД)
+func localRevocations(svc Service, interval time.Duration) *authmw.RevocationCache {
+	return authmw.NewRevocationCache(func(context.Context) (authmw.RevocationList, error) {
+		return svc.RevocationList()
+	}, interval)
+}
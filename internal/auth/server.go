@@ -1,26 +1,187 @@
 package auth
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+
+	"template/internal/audit"
+	"template/internal/db"
+	"template/internal/mail"
+	"template/internal/middleware/authmw"
+	"template/internal/svcauth"
 )
 
-func Start(port, jwtSecret, ttlStr string) {
-	ttl, err := time.ParseDuration(ttlStr)
+type Config struct {
+	Port     string
+	DSN      string // пусто — пользователи в памяти
+	TokenTTL string
+	// RefreshTTL — срок жизни refresh-токена (сессии без активности)
+	RefreshTTL string
+
+	KeysDir    string // каталог ключей подписи (см. KeySet)
+	SigningKID string // активный ключ; пусто — последний по имени
+
+	BcryptCost            string
+	PasswordMinLength     string
+	PasswordMinClasses    string
+	BreachedPasswordsFile string // необязательный список утёкших паролей
+
+	SelfRegisterRole string // роль при самостоятельной регистрации; пусто — выключена
+	ServiceClients   string // "id:secret,..." — учётки сервисов для /auth/token
+
+	LoginMaxFailures string // неудач подряд до блокировки учётки
+	LoginLockout     string // длительность блокировки
+	LoginBackoffBase string // пауза после первой неудачи, дальше удваивается
+	LoginBackoffMax  string
+
+	AuditURL string // пусто — события в audit не отправляются
+
+	MFARequiredRoles string // роли через запятую, которым TOTP обязателен
+
+	// письма восстановления пароля: SMTP, если задан адрес, иначе в файл (пусто — stdout)
+	MailSMTPAddr     string
+	MailSMTPUser     string
+	MailSMTPPassword string
+	MailFrom         string
+	MailFile         string
+	PasswordResetURL string // страница сброса, к ней добавляется ?token=
+	PasswordResetTTL string
+
+	// OIDC: iss ID-токенов (внешний URL префикса /auth) и публичные клиенты
+	// "id=redirect_uri|redirect_uri,..."; остальные клиенты — через /auth/oidc/clients
+	OIDCIssuer  string
+	OIDCClients string
+
+	// первичный пользователь, создаётся в пустой БД
+	BootstrapUser     string
+	BootstrapPassword string
+	BootstrapRole     string
+}
+
+func Start(cfg Config) {
+	ttl, err := time.ParseDuration(cfg.TokenTTL)
+	if err != nil {
+		ttl = 15 * time.Minute
+	}
+	refreshTTL, err := time.ParseDuration(cfg.RefreshTTL)
 	if err != nil {
-		ttl = 24 * time.Hour
+		refreshTTL = 30 * 24 * time.Hour
 	}
 
 	repo := NewMemRepo()
-	svc := NewService(repo, jwtSecret, ttl)
+	store := "in-memory"
+	if cfg.DSN != "" {
+		repo = NewPgRepo(db.MustConnect(cfg.DSN))
+		store = "postgres"
+	}
+	if err := repo.EnsureSchema(); err != nil {
+		log.Fatalf("auth ensure schema: %v", err)
+	}
+	keys, err := LoadKeySet(cfg.KeysDir, cfg.SigningKID)
+	if err != nil {
+		log.Fatalf("auth keys: %v", err)
+	}
+	clients, err := ParseServiceClients(cfg.ServiceClients)
+	if err != nil {
+		log.Fatalf("auth: %v", err)
+	}
+	oidcClients, err := ParseOIDCClients(cfg.OIDCClients)
+	if err != nil {
+		log.Fatalf("auth: %v", err)
+	}
+	policy, err := LoadPasswordPolicy(atoi(cfg.PasswordMinLength, 8), atoi(cfg.PasswordMinClasses, 2), cfg.BreachedPasswordsFile)
+	if err != nil {
+		log.Fatalf("auth password policy: %v", err)
+	}
+	svc := NewService(repo, Options{
+		Keys:             keys,
+		TokenTTL:         ttl,
+		RefreshTTL:       refreshTTL,
+		Hasher:           NewHasher(atoi(cfg.BcryptCost, 12)),
+		Policy:           policy,
+		SelfRegisterRole: cfg.SelfRegisterRole,
+		Clients:          clients,
+		Lockout: LockoutPolicy{
+			MaxFailures: atoi(cfg.LoginMaxFailures, 5),
+			Lockout:     duration(cfg.LoginLockout, 15*time.Minute),
+			BackoffBase: duration(cfg.LoginBackoffBase, time.Second),
+			BackoffMax:  duration(cfg.LoginBackoffMax, time.Minute),
+		},
+		Audit:       audit.NewClient(cfg.AuditURL, svcauth.NewClient(selfToken{keys: keys, repo: repo})),
+		MFARequired: ParseMFARoles(cfg.MFARequiredRoles),
+		Reset: PasswordReset{
+			Mailer: mail.New(cfg.MailSMTPAddr, cfg.MailSMTPUser, cfg.MailSMTPPassword, cfg.MailFrom, cfg.MailFile),
+			URL:    cfg.PasswordResetURL,
+			TTL:    duration(cfg.PasswordResetTTL, time.Hour),
+		},
+		OIDCIssuer: strings.TrimSuffix(cfg.OIDCIssuer, "/"),
+	})
+	if err := bootstrap(repo, svc, cfg); err != nil {
+		log.Fatalf("auth bootstrap: %v", err)
+	}
+	if err := seedOIDCClients(repo, oidcClients); err != nil {
+		log.Fatalf("auth oidc clients: %v", err)
+	}
 
 	r := mux.NewRouter()
 	authRouter := r.PathPrefix("/auth").Subrouter()
-	NewHandler(svc).Register(authRouter)
+	mw := authmw.New(keys, localRevocations(svc, 5*time.Second))
+	h := NewHandler(svc, mw, keys)
+	h.Register(authRouter)
+
+	// внутренние вызовы: только сервисы со своими токенами; прокси этот префикс наружу не пробрасывает
+	h.RegisterInternal(r.PathPrefix("/internal/auth").Subrouter())
 
-	log.Printf("[auth] :%s (ttl=%s)", port, ttl.String())
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	log.Printf("[auth] :%s (ttl=%s, refresh=%s, store=%s, kid=%s)", cfg.Port, ttl.String(), refreshTTL.String(), store, keys.signingKID)
+	log.Fatal(http.ListenAndServe(":"+cfg.Port, r))
+}
+
+// bootstrap заводит первого пользователя, если хранилище пустое
+func bootstrap(repo Repo, svc Service, cfg Config) error {
+	if cfg.BootstrapUser == "" || cfg.BootstrapPassword == "" {
+		return nil
+	}
+	n, err := repo.Count()
+	if err != nil || n > 0 {
+		return err
+	}
+	m, err := svc.CreateManager(cfg.BootstrapUser, cfg.BootstrapPassword, cfg.BootstrapRole)
+	if err != nil {
+		return err
+	}
+	log.Printf("[auth] bootstrap user %q (role=%s)", m.Username, m.Role)
+	return nil
+}
+
+// seedOIDCClients заводит клиентов из конфигурации, которых ещё нет
+func seedOIDCClients(repo Repo, clients []OIDCClient) error {
+	for _, c := range clients {
+		if _, ok := repo.OIDCClientByID(c.ID); ok {
+			continue
+		}
+		if _, err := repo.CreateOIDCClient(c); err != nil && !errors.Is(err, ErrOIDCClientExists) {
+			return err
+		}
+	}
+	return nil
+}
+
+func atoi(s string, def int) int {
+	if v, err := strconv.Atoi(s); err == nil {
+		return v
+	}
+	return def
+}
+
+func duration(s string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d
+	}
+	return def
 }
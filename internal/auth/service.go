@@ -1,45 +1,341 @@
 package auth
 
 import (
+	"context"
 	"errors"
+	"log"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"template/internal/audit"
+	"template/internal/middleware/authmw"
+)
+
+var (
+	ErrInvalidCredentials   = errors.New("invalid credentials")
+	ErrRegistrationDisabled = errors.New("self-registration disabled")
+	ErrInvalidInput         = errors.New("username and role required")
 )
 
 type Service interface {
-	Login(username, password string) (string, string, time.Duration, error)
+	// Login — вход по паролю; client.IP — для счётчиков неудачных попыток,
+	// client целиком — в запись о сессии.
+	// Если у пользователя включён (или обязателен) TOTP, вместо токенов — Challenge
+	Login(username, password string, client ClientInfo) (LoginResult, error)
+	// LoginMFA — второй шаг входа: код TOTP или резервный код
+	LoginMFA(challenge, code string, client ClientInfo) (TokenPair, error)
+	// Unlock — снять блокировку после неудачных входов (администратор)
+	Unlock(userID int64, client ClientInfo) error
+	// SelfRegister — регистрация самим пользователем, роль задаётся конфигурацией
+	SelfRegister(username, password string, client ClientInfo) (LoginResult, error)
+	// Register — регистрация администратором с произвольной ролью
+	Register(username, password, role string, client ClientInfo) (LoginResult, error)
+	CreateManager(username, password, role string) (Manager, error)
+
+	// администрирование пользователей; actorID — кто выполняет (себя нельзя
+	// выключить, удалить или понизить)
+	ListUsers(f UserFilter) ([]Manager, int, error)
+	GetUser(id int64) (Manager, error)
+	CreateUser(in NewUser) (Manager, error)
+	UpdateUser(actorID, id int64, upd UserUpdate, client ClientInfo) (Manager, error)
+	SetDisabled(actorID, id int64, disabled bool, client ClientInfo) error
+	DeleteUser(actorID, id int64, client ClientInfo) error
+	ResetPassword(id int64, password string, client ClientInfo) error
+	// ChangePassword — смена своего пароля; сессия keepSession остаётся,
+	// неверный текущий пароль ограничивается как попытка входа
+	ChangePassword(userID int64, current, next, keepSession string, client ClientInfo) error
+	// ChangeExpiredPassword — обязательная смена пароля при входе (по challenge)
+	ChangeExpiredPassword(challenge, next string, client ClientInfo) (LoginResult, error)
+	// ForgotPassword — письмо со ссылкой сброса на email пользователя (логин или email)
+	ForgotPassword(login string, client ClientInfo) error
+	// ResetPasswordByToken — новый пароль по одноразовому токену из письма
+	ResetPasswordByToken(token, password string, client ClientInfo) error
+
+	// Refresh — ротация refresh-токена; повторное предъявление отзывает всё семейство
+	// Refresh — обновление токенов входа в приложение (не OIDC)
+	Refresh(refreshToken string, client ClientInfo) (TokenPair, error)
+	// Logout — завершение сессии, к которой относится refresh-токен
+	Logout(refreshToken string) error
+	// SessionActive — не отозвана ли сессия (семейство refresh-токенов)
+	SessionActive(sessionID string) (bool, error)
+	// ListSessions — активные сессии пользователя, последние по активности первыми
+	ListSessions(userID int64) ([]Session, error)
+	// EndSession — завершить одну сессию пользователя
+	EndSession(userID int64, sessionID string, client ClientInfo) error
+	// EndOtherSessions — завершить все сессии пользователя, кроме keep
+	EndOtherSessions(userID int64, keep string, client ClientInfo) error
+
+	ClientToken(clientID, clientSecret string) (string, time.Duration, error)
+
+	// организации и ключи интеграций
+	CreateOrganization(name string) (Organization, error)
+	ListOrganizations() ([]Organization, error)
+	CreateAPIKey(in NewAPIKey, client ClientInfo) (APIKey, string, error)
+	ListAPIKeys(orgID int64) ([]APIKey, error)
+	RevokeAPIKey(id int64, client ClientInfo) error
+	IntrospectAPIKey(key string) (authmw.APIKeyInfo, error)
+
+	// OpenID Connect: клиенты, /authorize (пароль и второй фактор), обмен кода
+	CreateOIDCClient(in NewOIDCClient, client ClientInfo) (OIDCClient, string, error)
+	ListOIDCClients() ([]OIDCClient, error)
+	DeleteOIDCClient(id string, client ClientInfo) error
+	CheckAuthorize(req AuthorizeRequest) (OIDCClient, error)
+	AuthorizeLogin(req AuthorizeRequest, username, password string, client ClientInfo) (AuthorizeResult, error)
+	AuthorizeMFA(req AuthorizeRequest, challenge, code string, client ClientInfo) (string, error)
+	ExchangeCode(in CodeExchange, client ClientInfo) (OIDCTokens, error)
+	RefreshOIDC(in RefreshGrant, client ClientInfo) (TokenPair, error)
+	UserInfo(userID int64, scope string) (map[string]any, error)
+	OIDCIssuer() string
+
+	// RevokeAccess — отзыв access-токена по jti до его истечения
+	RevokeAccess(jti string, exp time.Time) error
+	// RevocationList — действующий список отзыва для authmw
+	RevocationList() (authmw.RevocationList, error)
+
+	// EnrollTOTP — новый секрет TOTP; включается после ConfirmTOTP
+	EnrollTOTP(userID int64) (TOTPEnrollment, error)
+	// ConfirmTOTP — включает TOTP по первому коду, возвращает резервные коды
+	ConfirmTOTP(userID int64, code string, client ClientInfo) ([]string, error)
+	// EnrollmentUser — пользователь из enroll-challenge (TOTP обязателен, но не настроен)
+	EnrollmentUser(challenge string) (int64, error)
+	// ConfirmEnrollment — ConfirmTOTP по enroll-challenge, заодно завершает вход
+	ConfirmEnrollment(challenge, code string, client ClientInfo) ([]string, TokenPair, error)
+	// DisableTOTP, RegenerateRecoveryCodes — неверные коды ограничиваются как попытки входа
+	DisableTOTP(userID int64, code string, client ClientInfo) error
+	RegenerateRecoveryCodes(userID int64, code string, client ClientInfo) ([]string, error)
+}
+
+type Options struct {
+	Keys       *KeySet
+	TokenTTL   time.Duration
+	RefreshTTL time.Duration
+	Hasher     Hasher
+	Policy     *PasswordPolicy
+
+	SelfRegisterRole string // пусто — самостоятельная регистрация выключена
+	Clients          ServiceClients
+	Lockout          LockoutPolicy
+	Audit            *audit.Client // nil — события не отправляются
+	MFARequired      MFAPolicy
+	Reset            PasswordReset
+	OIDCIssuer       string // iss ID-токенов и базовый URL эндпойнтов OIDC
 }
 
 type service struct {
-	repo   Repo
-	secret []byte
-	ttl    time.Duration
+	repo       Repo
+	keys       *KeySet
+	ttl        time.Duration
+	refreshTTL time.Duration
+	hasher     Hasher
+	policy     *PasswordPolicy
+	selfRole   string
+	clients    ServiceClients
+	lockout    LockoutPolicy
+	limiter    *loginLimiter
+	audit      *audit.Client
+	mfa        MFAPolicy
+	reset      PasswordReset
+	issuer     string
 }
 
-func NewService(repo Repo, jwtSecret string, ttl time.Duration) Service {
-	return &service{repo: repo, secret: []byte(jwtSecret), ttl: ttl}
+func NewService(repo Repo, opt Options) Service {
+	return &service{
+		repo:       repo,
+		keys:       opt.Keys,
+		ttl:        opt.TokenTTL,
+		refreshTTL: opt.RefreshTTL,
+		hasher:     opt.Hasher,
+		policy:     opt.Policy,
+		selfRole:   opt.SelfRegisterRole,
+		clients:    opt.Clients,
+		lockout:    opt.Lockout,
+		limiter:    newLoginLimiter(opt.Lockout),
+		audit:      opt.Audit,
+		mfa:        opt.MFARequired,
+		reset:      opt.Reset,
+		issuer:     opt.OIDCIssuer,
+	}
 }
 
-func (s *service) Login(username, password string) (string, string, time.Duration, error) {
-	uName := strings.ToLower(strings.TrimSpace(username))
-	pwd := strings.TrimSpace(password)
+func (s *service) Login(username, password string, client ClientInfo) (LoginResult, error) {
+	u, err := s.checkCredentials(username, password, client)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return s.completeLogin(u, client)
+}
 
+// checkCredentials — проверка пароля с защитой от перебора: пауза между
+// попытками по логину и IP, блокировка учётки после LockoutPolicy.MaxFailures.
+// Попытки не сбрасывает — это делает succeeded в конце входа
+func (s *service) checkCredentials(username, password string, client ClientInfo) (Manager, error) {
+	uName := strings.ToLower(strings.TrimSpace(username))
+	now := time.Now()
+	if err := s.wait(uName, client.IP, now); err != nil {
+		return Manager{}, err
+	}
 	u, ok := s.repo.ByUsername(uName)
-	if !ok || strings.TrimSpace(u.Password) != pwd {
-		return "", "", 0, errors.New("invalid credentials")
+	if !ok {
+		s.hasher.dummy(password)
+		s.limiter.fail("user:"+uName, now)
+		s.limiter.fail("ip:"+client.IP, now)
+		return Manager{}, ErrInvalidCredentials
+	}
+	if err := locked(u, now); err != nil {
+		s.hasher.dummy(password)
+		return Manager{}, err
+	}
+	valid, rehash := s.hasher.Verify(u.Password, password)
+	if !valid {
+		s.failed(u, client, now)
+		return Manager{}, ErrInvalidCredentials
+	}
+	// счётчики попыток сбрасываются только после всего входа (succeeded), иначе
+	// знающий пароль вводил бы его между попытками кода и перебирал второй фактор
+	if u.Disabled {
+		return Manager{}, ErrAccountDisabled
+	}
+	if rehash {
+		// параметры хеширования сменились — пересчитываем, пока знаем пароль
+		if h, err := s.hasher.Hash(password); err == nil {
+			if err := s.repo.UpdatePassword(u.ID, h); err != nil {
+				log.Printf("[auth] rehash %q: %v", u.Username, err)
+			}
+		}
+	}
+	return u, nil
+}
+
+// wait — пауза между попытками по логину и по IP ещё не истекла
+func (s *service) wait(username, ip string, now time.Time) error {
+	if d := max(s.limiter.wait("user:"+username, now), s.limiter.wait("ip:"+ip, now)); d > 0 {
+		return &ThrottleError{Err: ErrTooManyAttempts, RetryAfter: d}
+	}
+	return nil
+}
+
+func locked(u Manager, now time.Time) error {
+	if u.LockedUntil != nil && now.Before(*u.LockedUntil) {
+		return &ThrottleError{Err: ErrAccountLocked, RetryAfter: u.LockedUntil.Sub(now)}
 	}
+	return nil
+}
+
+// failed — неудачная попытка (пароль или второй фактор) существующего пользователя
+func (s *service) failed(u Manager, client ClientInfo, now time.Time) {
+	s.limiter.fail("ip:"+client.IP, now)
+	if n := s.limiter.fail("user:"+u.Username, now); s.lockout.MaxFailures > 0 && n >= s.lockout.MaxFailures {
+		s.lock(u, client, now)
+	}
+}
+
+// succeeded — вход завершён целиком (пароль и, если нужен, второй фактор)
+func (s *service) succeeded(u Manager, ip string) {
+	s.limiter.reset("user:" + u.Username)
+	s.limiter.reset("ip:" + ip)
+	if u.LockedUntil != nil {
+		_ = s.repo.SetLockedUntil(u.ID, nil)
+	}
+}
+
+func (s *service) lock(u Manager, client ClientInfo, now time.Time) {
+	until := now.Add(s.lockout.Lockout)
+	if err := s.repo.SetLockedUntil(u.ID, &until); err != nil {
+		log.Printf("[auth] lock %q: %v", u.Username, err)
+		return
+	}
+	s.limiter.reset("user:" + u.Username)
+	log.Printf("[auth] account %q locked until %s (ip=%s)", u.Username, until.Format(time.RFC3339), client.IP)
+	s.emit(client, "auth.lockout", u.Username, http.StatusLocked, "ip="+client.IP+" until="+until.Format(time.RFC3339))
+}
+
+func (s *service) Unlock(userID int64, client ClientInfo) error {
+	u, ok := s.repo.ByID(userID)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := s.repo.SetLockedUntil(u.ID, nil); err != nil {
+		return err
+	}
+	s.limiter.reset("user:" + u.Username)
+	s.emit(client, "auth.unlock", u.Username, http.StatusOK, "")
+	return nil
+}
+
+// emit — событие в audit, в фоне: вход не должен ждать аудит; URI — путь
+// запроса, вызвавшего событие
+func (s *service) emit(client ClientInfo, action, username string, status int, details string) {
+	if s.audit == nil {
+		return
+	}
+	ev := audit.Event{
+		SourceService: "auth",
+		TargetService: "auth",
+		URI:           client.URI,
+		HTTPStatus:    status,
+		At:            time.Now(),
+		UserID:        username,
+		Action:        action,
+		ResponseBody:  details,
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.audit.Send(ctx, ev); err != nil {
+			log.Printf("[auth] audit %s: %v", action, err)
+		}
+	}()
+}
+
+func (s *service) SelfRegister(username, password string, client ClientInfo) (LoginResult, error) {
+	if s.selfRole == "" {
+		return LoginResult{}, ErrRegistrationDisabled
+	}
+	return s.Register(username, password, s.selfRole, client)
+}
+
+func (s *service) Register(username, password, role string, client ClientInfo) (LoginResult, error) {
+	u, err := s.CreateManager(username, password, role)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return s.completeLogin(u, client)
+}
+
+func (s *service) CreateManager(username, password, role string) (Manager, error) {
+	return s.CreateUser(NewUser{Username: username, Password: password, Role: role})
+}
+
+// issueAccess — access-токен сессии sess. Токен OIDC-приложения выдаётся ему
+// (aud = id приложения) без разрешений: годится только для /userinfo
+func (s *service) issueAccess(u Manager, sess Session) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"sub":  u.Username,
+		"uid":  u.ID,
 		"role": u.Role,
-		"exp":  time.Now().Add(s.ttl).Unix(),
-		"iss":  "auth",
+		"sid":  sess.ID,
+		"jti":  newTokenID(),
+		"iat":  now.Unix(),
+		"exp":  now.Add(s.ttl).Unix(),
+		"iss":  authmw.Issuer,
 	}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenStr, err := t.SignedString(s.secret)
-	if err != nil {
-		return "", "", 0, err
+	if sess.ClientID != "" {
+		claims["aud"] = sess.ClientID
+		claims["scope"] = sess.Scope
+	} else {
+		perms, err := s.repo.RolePermissions(u.Role)
+		if err != nil {
+			return "", err
+		}
+		claims["aud"] = authmw.Audience
+		claims["perms"] = perms
+	}
+	if u.LogisticsPointID != nil {
+		claims["lpid"] = *u.LogisticsPointID
 	}
-	return tokenStr, u.Role, s.ttl, nil
+	return s.keys.Sign(claims)
 }
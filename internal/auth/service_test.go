@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestService — сервис поверх NewMemRepo с эфемерными ключами; в репозитории
+// уже есть пользователи office/office и logi/logi
+func newTestService(t *testing.T) *service {
+	t.Helper()
+	keys, err := LoadKeySet("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPasswordPolicy(8, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(NewMemRepo(), Options{
+		Keys:       keys,
+		TokenTTL:   time.Minute,
+		RefreshTTL: time.Hour,
+		Hasher:     NewHasher(4),
+		Policy:     policy,
+		Lockout:    LockoutPolicy{MaxFailures: 5, Lockout: time.Minute, BackoffBase: time.Millisecond, BackoffMax: time.Millisecond},
+		OIDCIssuer: "https://a7.example/auth",
+	}).(*service)
+}
+
+// login — вход без второго фактора, токены обязательны
+func login(t *testing.T, s *service, username, password string) TokenPair {
+	t.Helper()
+	res, err := s.Login(username, password, ClientInfo{IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Challenge != nil {
+		t.Fatal("unexpected login challenge")
+	}
+	return res.Tokens
+}
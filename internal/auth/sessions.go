@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// ClientInfo — откуда пришёл запрос входа или обновления токенов
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	URI       string // путь запроса — для событий аудита
+}
+
+// Session — вход пользователя на устройстве; id совпадает с семейством
+// refresh-токенов и с sid в access-токенах
+type Session struct {
+	ID         string
+	UserID     int64
+	IP         string
+	UserAgent  string
+	Device     string // кратко из User-Agent: «Chrome, Windows»
+	CreatedAt  time.Time
+	LastSeenAt time.Time // последний вход или обновление токенов
+	ExpiresAt  time.Time // когда истечёт последний refresh-токен
+	EndedAt    *time.Time
+	// ClientID — OIDC-приложение, которому выданы токены (обновляются только им
+	// через /auth/token); пусто — вход в само приложение (/auth/refresh)
+	ClientID string
+	Scope    string // scope, выданные приложению
+}
+
+// maxUserAgent — длиннее User-Agent не храним
+const maxUserAgent = 512
+
+// startSession — новая сессия и первая пара токенов в ней
+func (s *service) startSession(u Manager, client ClientInfo) (TokenPair, error) {
+	return s.startClientSession(u, client, "", "")
+}
+
+// startClientSession — сессия OIDC-приложения clientID с выданными ему scope
+func (s *service) startClientSession(u Manager, client ClientInfo, clientID, scope string) (TokenPair, error) {
+	now := time.Now()
+	ua := truncate(client.UserAgent, maxUserAgent)
+	sess := Session{
+		ID:         newFamilyID(),
+		UserID:     u.ID,
+		IP:         client.IP,
+		UserAgent:  ua,
+		Device:     deviceName(ua),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.refreshTTL),
+		ClientID:   clientID,
+		Scope:      scope,
+	}
+	if err := s.repo.CreateSession(sess); err != nil {
+		return TokenPair{}, err
+	}
+	return s.issuePair(u, sess)
+}
+
+func (s *service) ListSessions(userID int64) ([]Session, error) {
+	if _, ok := s.repo.ByID(userID); !ok {
+		return nil, ErrUserNotFound
+	}
+	return s.repo.UserSessions(userID, time.Now())
+}
+
+// EndSession — завершить сессию пользователя userID (свою или, для администратора, чужую)
+func (s *service) EndSession(userID int64, sessionID string, client ClientInfo) error {
+	sess, ok := s.repo.SessionByID(sessionID)
+	if !ok || sess.UserID != userID || sess.EndedAt != nil {
+		return ErrSessionNotFound
+	}
+	if err := s.endSession(sessionID); err != nil {
+		return err
+	}
+	u, _ := s.repo.ByID(userID)
+	s.emit(client, "auth.session_ended", u.Username, http.StatusOK, "sid="+sessionID)
+	return nil
+}
+
+// EndOtherSessions — завершить все сессии пользователя, кроме keep (пусто — все)
+func (s *service) EndOtherSessions(userID int64, keep string, client ClientInfo) error {
+	u, ok := s.repo.ByID(userID)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := s.endUserSessions(userID, keep); err != nil {
+		return err
+	}
+	s.emit(client, "auth.sessions_ended", u.Username, http.StatusOK, "kept="+keep)
+	return nil
+}
+
+// deviceName — браузер и ОС по User-Agent, без претензий на точность
+func deviceName(ua string) string {
+	if ua == "" {
+		return "unknown"
+	}
+	browser := "other"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"YaBrowser/", "Yandex Browser"},
+		{"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+		{"curl/", "curl"}, {"PostmanRuntime/", "Postman"}, {"Go-http-client/", "Go"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	os := ""
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"},
+		{"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			os = o.name
+			break
+		}
+	}
+	if os == "" {
+		return browser
+	}
+	return browser + ", " + os
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP по RFC 6238 в варианте, который понимают Google Authenticator и аналоги:
+// HMAC-SHA1, 6 цифр, шаг 30 секунд
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	totpSkew   = 1 // соседние шаги: часы телефона могут расходиться с сервером
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() string {
+	b := make([]byte, 20) // 160 бит, как рекомендует RFC 4226
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b32.EncodeToString(b)
+}
+
+// totpURI — otpauth://-ссылка для QR-кода приложения-аутентификатора
+func totpURI(issuer, account, secret string) string {
+	q := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + q.Encode()
+}
+
+func totpCode(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1_000_000), nil
+}
+
+// verifyTOTP возвращает шаг, на котором совпал код. Шаги не новее lastStep
+// не принимаются — один и тот же код дважды не сработает.
+func verifyTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if secret == "" || len(code) != totpDigits {
+		return 0, false
+	}
+	cur := now.Unix() / totpPeriod
+	for d := int64(-totpSkew); d <= totpSkew; d++ {
+		step := cur + d
+		if step <= lastStep {
+			continue
+		}
+		want, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes — одноразовые резервные коды вида "abcde-fghij"
+func newRecoveryCodes(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		s := strings.ToLower(b32.EncodeToString(b))[:10]
+		out[i] = s[:5] + "-" + s[5:]
+	}
+	return out
+}
+
+// hashRecoveryCode — хеш кода без учёта регистра, пробелов и дефиса
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
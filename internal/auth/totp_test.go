@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// секрет из RFC 6238, приложение B: ASCII "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestTOTPCodeRFC6238 — векторы SHA1 из RFC 6238; у нас 6 цифр, это младшие
+// разряды 8-значных значений из RFC
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfcSecret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("T=%d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+	// секрет из приложения обычно вводят в нижнем регистре
+	if got, _ := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1); got != "287082" {
+		t.Errorf("lower-case secret: got %s", got)
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("invalid secret must fail")
+	}
+}
+
+func TestVerifyTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	cur := now.Unix() / totpPeriod
+	for d := int64(-3); d <= 3; d++ {
+		code, _ := totpCode(rfcSecret, cur+d)
+		step, ok := verifyTOTP(rfcSecret, code, now, 0)
+		want := d >= -totpSkew && d <= totpSkew
+		if ok != want {
+			t.Errorf("step %+d: ok=%v, want %v", d, ok, want)
+		}
+		if ok && step != cur+d {
+			t.Errorf("step %+d: matched step %d, want %d", d, step, cur+d)
+		}
+	}
+}
+
+func TestVerifyTOTPReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	cur := now.Unix() / totpPeriod
+	code, _ := totpCode(rfcSecret, cur)
+	step, ok := verifyTOTP(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("fresh code rejected")
+	}
+	// код уже использованного шага и более старых не принимается
+	if _, ok := verifyTOTP(rfcSecret, code, now, step); ok {
+		t.Error("same-step replay accepted")
+	}
+	prev, _ := totpCode(rfcSecret, cur-1)
+	if _, ok := verifyTOTP(rfcSecret, prev, now, step); ok {
+		t.Error("older step accepted after a newer one was used")
+	}
+	next, _ := totpCode(rfcSecret, cur+1)
+	if _, ok := verifyTOTP(rfcSecret, next, now, step); !ok {
+		t.Error("newer step rejected")
+	}
+}
+
+func TestVerifyTOTPInput(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := totpCode(rfcSecret, now.Unix()/totpPeriod)
+	if _, ok := verifyTOTP(rfcSecret, " "+code[:3]+" "+code[3:]+" ", now, 0); !ok {
+		t.Error("code with spaces rejected")
+	}
+	for _, c := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := verifyTOTP(rfcSecret, c, now, 0); ok {
+			t.Errorf("%q accepted", c)
+		}
+	}
+	if _, ok := verifyTOTP("", code, now, 0); ok {
+		t.Error("empty secret accepted")
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := hashRecoveryCode("abcde-fghij")
+	for _, c := range []string{"abcdefghij", "ABCDE-FGHIJ", " abcde fghij ", "Abcde-Fghij"} {
+		if hashRecoveryCode(c) != want {
+			t.Errorf("%q hashes differently", c)
+		}
+	}
+	if hashRecoveryCode("abcde-fghik") == want {
+		t.Error("different code, same hash")
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes := newRecoveryCodes(10)
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' {
+			t.Errorf("bad format %q", c)
+		}
+		if seen[c] {
+			t.Errorf("duplicate %q", c)
+		}
+		seen[c] = true
+	}
+}
@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"template/internal/middleware/authmw"
+)
+
+var (
+	ErrAccountDisabled  = errors.New("account disabled")
+	ErrPasswordReused   = errors.New("new password must differ from the current one")
+	ErrPointNotAllowed  = errors.New("logistics_point_id is only for logistics_manager")
+	ErrSelfModification = errors.New("cannot disable, delete or demote yourself")
+	ErrInvalidEmail     = errors.New("invalid email")
+)
+
+// NewUser — учётка, которую заводит администратор
+type NewUser struct {
+	Username           string
+	Email              string
+	Password           string
+	Role               string
+	LogisticsPointID   *int64
+	MustChangePassword bool
+}
+
+// UserUpdate — изменяемые администратором поля; nil — не трогать
+type UserUpdate struct {
+	Email *string // "" — удалить адрес
+	Role  *string
+	// SetLogisticsPoint — менять привязку; LogisticsPointID == nil снимает её
+	SetLogisticsPoint bool
+	LogisticsPointID  *int64
+}
+
+func (s *service) ListUsers(f UserFilter) ([]Manager, int, error) {
+	f.Query = strings.ToLower(strings.TrimSpace(f.Query))
+	return s.repo.List(f)
+}
+
+func (s *service) GetUser(id int64) (Manager, error) {
+	u, ok := s.repo.ByID(id)
+	if !ok {
+		return Manager{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+// CreateUser — заводит пользователя, проверив пароль по политике
+func (s *service) CreateUser(in NewUser) (Manager, error) {
+	uName := strings.ToLower(strings.TrimSpace(in.Username))
+	if uName == "" || in.Role == "" {
+		return Manager{}, ErrInvalidInput
+	}
+	if err := s.checkRole(in.Role, in.LogisticsPointID); err != nil {
+		return Manager{}, err
+	}
+	email, err := normalizeEmail(in.Email)
+	if err != nil {
+		return Manager{}, err
+	}
+	if err := s.policy.Validate(uName, in.Password); err != nil {
+		return Manager{}, err
+	}
+	hash, err := s.hasher.Hash(in.Password)
+	if err != nil {
+		return Manager{}, err
+	}
+	return s.repo.Create(Manager{
+		Username:           uName,
+		Email:              email,
+		Password:           hash,
+		Role:               in.Role,
+		LogisticsPointID:   in.LogisticsPointID,
+		MustChangePassword: in.MustChangePassword,
+	})
+}
+
+func (s *service) checkRole(role string, lpid *int64) error {
+	if role == authmw.RoleService {
+		return ErrUnknownRole
+	}
+	if ok, err := s.repo.RoleExists(role); err != nil {
+		return err
+	} else if !ok {
+		return ErrUnknownRole
+	}
+	if lpid != nil && role != authmw.RoleLogisticsManager {
+		return ErrPointNotAllowed
+	}
+	return nil
+}
+
+// UpdateUser — смена роли и/или логточки; сессии пользователя отзываются,
+// чтобы новые разрешения вступили в силу при следующем входе
+func (s *service) UpdateUser(actorID, id int64, upd UserUpdate, client ClientInfo) (Manager, error) {
+	u, ok := s.repo.ByID(id)
+	if !ok {
+		return Manager{}, ErrUserNotFound
+	}
+	if upd.Role != nil {
+		if id == actorID && *upd.Role != u.Role {
+			return Manager{}, ErrSelfModification
+		}
+		u.Role = *upd.Role
+		if u.Role != authmw.RoleLogisticsManager && !upd.SetLogisticsPoint {
+			u.LogisticsPointID = nil
+		}
+	}
+	if upd.SetLogisticsPoint {
+		u.LogisticsPointID = upd.LogisticsPointID
+	}
+	if upd.Email != nil {
+		email, err := normalizeEmail(*upd.Email)
+		if err != nil {
+			return Manager{}, err
+		}
+		u.Email = email
+	}
+	if err := s.checkRole(u.Role, u.LogisticsPointID); err != nil {
+		return Manager{}, err
+	}
+	if err := s.repo.UpdateAccount(u); err != nil {
+		return Manager{}, err
+	}
+	if err := s.endUserSessions(u.ID, ""); err != nil {
+		return Manager{}, err
+	}
+	s.emit(client, "auth.user_updated", u.Username, http.StatusOK, "role="+u.Role+" lpid="+formatID(u.LogisticsPointID))
+	return s.GetUser(id)
+}
+
+// SetDisabled — выключение учётки сразу отзывает все её сессии
+func (s *service) SetDisabled(actorID, id int64, disabled bool, client ClientInfo) error {
+	if id == actorID && disabled {
+		return ErrSelfModification
+	}
+	u, ok := s.repo.ByID(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Disabled = disabled
+	if err := s.repo.UpdateAccount(u); err != nil {
+		return err
+	}
+	action := "auth.user_enabled"
+	if disabled {
+		action = "auth.user_disabled"
+		if err := s.endUserSessions(u.ID, ""); err != nil {
+			return err
+		}
+	}
+	s.emit(client, action, u.Username, http.StatusOK, "")
+	return nil
+}
+
+func (s *service) DeleteUser(actorID, id int64, client ClientInfo) error {
+	if id == actorID {
+		return ErrSelfModification
+	}
+	u, ok := s.repo.ByID(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	// refresh-токены удалятся вместе с пользователем, access-токены — отзываем
+	if err := s.endUserSessions(id, ""); err != nil {
+		return err
+	}
+	if err := s.repo.Delete(id); err != nil {
+		return err
+	}
+	s.emit(client, "auth.user_deleted", u.Username, http.StatusOK, "")
+	return nil
+}
+
+// ResetPassword — временный пароль от администратора; при входе его придётся сменить
+func (s *service) ResetPassword(id int64, password string, client ClientInfo) error {
+	u, ok := s.repo.ByID(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := s.setPassword(u, password, true); err != nil {
+		return err
+	}
+	if err := s.endUserSessions(u.ID, ""); err != nil {
+		return err
+	}
+	s.emit(client, "auth.password_reset", u.Username, http.StatusOK, "by admin")
+	return nil
+}
+
+// ChangePassword — смена пароля самим пользователем; остальные его сессии,
+// кроме keepSession, отзываются. Неверный текущий пароль считается неудачной
+// попыткой входа (ограничитель и блокировка те же, что у Login).
+func (s *service) ChangePassword(userID int64, current, next, keepSession string, client ClientInfo) error {
+	u, ok := s.repo.ByID(userID)
+	if !ok {
+		return ErrUserNotFound
+	}
+	now := time.Now()
+	if err := s.wait(u.Username, client.IP, now); err != nil {
+		return err
+	}
+	if err := locked(u, now); err != nil {
+		return err
+	}
+	if valid, _ := s.hasher.Verify(u.Password, current); !valid {
+		s.failed(u, client, now)
+		return ErrInvalidCredentials
+	}
+	if err := s.setPassword(u, next, false); err != nil {
+		return err
+	}
+	if err := s.endUserSessions(u.ID, keepSession); err != nil {
+		return err
+	}
+	s.emit(client, "auth.password_changed", u.Username, http.StatusOK, "")
+	return nil
+}
+
+// ChangeExpiredPassword — обязательная смена пароля по challenge-токену входа;
+// дальше вход продолжается как обычно (в том числе со вторым фактором)
+func (s *service) ChangeExpiredPassword(challenge, next string, client ClientInfo) (LoginResult, error) {
+	u, err := s.parseChallenge(challenge, challengePassword)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if err := s.setPassword(u, next, false); err != nil {
+		return LoginResult{}, err
+	}
+	if err := s.endUserSessions(u.ID, ""); err != nil {
+		return LoginResult{}, err
+	}
+	s.emit(client, "auth.password_changed", u.Username, http.StatusOK, "forced")
+	u, err = s.GetUser(u.ID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return s.completeLogin(u, client)
+}
+
+func (s *service) setPassword(u Manager, password string, mustChange bool) error {
+	if err := s.policy.Validate(u.Username, password); err != nil {
+		return err
+	}
+	if same, _ := s.hasher.Verify(u.Password, password); same {
+		return ErrPasswordReused
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdatePassword(u.ID, hash); err != nil {
+		return err
+	}
+	u.MustChangePassword = mustChange
+	return s.repo.UpdateAccount(u)
+}
+
+// normalizeEmail — голый адрес в нижнем регистре; пустой адрес допустим
+func normalizeEmail(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	a, err := netmail.ParseAddress(s)
+	if err != nil || a.Name != "" || a.Address != s {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(a.Address), nil
+}
+
+func formatID(id *int64) string {
+	if id == nil {
+		return "-"
+	}
+	return strconv.FormatInt(*id, 10)
+}
+
+// activeUser — пользователь сессии, если учётка не выключена
+func (s *service) activeUser(id int64) (Manager, bool) {
+	u, ok := s.repo.ByID(id)
+	return u, ok && !u.Disabled
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Query — построитель условий WHERE с нумерацией параметров $n. В текст
+// запроса попадают только строки из кода; значения — всегда параметрами.
+type Query struct {
+	where []string
+	args  []any
+}
+
+// Arg добавляет значение параметром и возвращает его плейсхолдер ($n)
+func (q *Query) Arg(v any) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// Where добавляет условие (через AND); каждый "?" в cond — очередное значение из args
+func (q *Query) Where(cond string, args ...any) {
+	var b strings.Builder
+	n := 0
+	for _, r := range cond {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		if n == len(args) {
+			panic("db.Query: more placeholders than args in " + cond)
+		}
+		b.WriteString(q.Arg(args[n]))
+		n++
+	}
+	if n != len(args) {
+		panic("db.Query: more args than placeholders in " + cond)
+	}
+	q.where = append(q.where, "("+b.String()+")")
+}
+
+// WhereSQL — " WHERE ..." или пусто, если условий нет
+func (q *Query) WhereSQL() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+func (q *Query) Args() []any { return q.args }
+
+// Clone — копия, к которой можно добавлять условия независимо (например,
+// общий фильтр для COUNT и он же с курсором для страницы)
+func (q *Query) Clone() *Query {
+	return &Query{where: append([]string(nil), q.where...), args: append([]any(nil), q.args...)}
+}
+
+// Contains — шаблон LIKE «подстрока s» с экранированием %, _ и \
+func Contains(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(s) + "%"
+}
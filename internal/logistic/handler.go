@@ -2,25 +2,35 @@ package logistic
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
+
+	"template/internal/appstatus"
+	"template/internal/middleware/authmw"
 )
 
-type Handler struct{ svc Service }
+type Handler struct {
+	svc Service
+	mw  *authmw.MW
+}
 
-func NewHandler(svc Service) *Handler { return &Handler{svc: svc} }
+func NewHandler(svc Service, mw *authmw.MW) *Handler { return &Handler{svc: svc, mw: mw} }
 
 func (h *Handler) Register(r *mux.Router) {
 	// здесь r — уже суброутер с префиксом /logistic
-	r.HandleFunc("/applications", h.listApps).Methods("GET")
-	r.HandleFunc("/applications/{id:[0-9]+}", h.getApp).Methods("GET")
-	r.HandleFunc("/applications/{id:[0-9]+}/status", h.updateAppStatus).Methods("POST")
+	can := func(perm string, f http.HandlerFunc) http.Handler { return h.mw.RequirePermission(perm)(f) }
+
+	r.Handle("/applications", can(authmw.PermLogisticApplicationsRead, h.listApps)).Methods("GET")
+	r.Handle("/applications/{id:[0-9]+}", can(authmw.PermLogisticApplicationsRead, h.getApp)).Methods("GET")
+	r.Handle("/applications/{id:[0-9]+}/status", can(authmw.PermLogisticApplicationsUpdateStatus, h.updateAppStatus)).Methods("POST")
+	r.Handle("/applications/{id:[0-9]+}/history", can(authmw.PermLogisticApplicationsRead, h.appHistory)).Methods("GET")
 
-	r.HandleFunc("/routes", h.createRoute).Methods("POST")
-	r.HandleFunc("/routes/{routeId:[0-9]+}/assign/{applicationId:[0-9]+}", h.assign).Methods("POST")
-	r.HandleFunc("/routes/{routeId:[0-9]+}/send", h.sendRoute).Methods("POST")
+	r.Handle("/routes", can(authmw.PermRoutesCreate, h.createRoute)).Methods("POST")
+	r.Handle("/routes/{routeId:[0-9]+}/assign/{applicationId:[0-9]+}", can(authmw.PermRoutesAssign, h.assign)).Methods("POST")
+	r.Handle("/routes/{routeId:[0-9]+}/send", can(authmw.PermRoutesSend, h.sendRoute)).Methods("POST")
 
 	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 }
@@ -32,7 +42,7 @@ func (h *Handler) listApps(w http.ResponseWriter, r *http.Request) {
 	}
 	out, err := h.svc.ListLogApps(r.Context(), st)
 	if err != nil {
-		http.Error(w, "server error", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 	respondJSON(w, http.StatusOK, out)
@@ -42,7 +52,7 @@ func (h *Handler) getApp(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	app, err := h.svc.GetLogApp(r.Context(), id)
 	if err != nil {
-		http.NotFound(w, r)
+		writeError(w, err)
 		return
 	}
 	respondJSON(w, http.StatusOK, app)
@@ -55,13 +65,23 @@ func (h *Handler) updateAppStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	if err := h.svc.UpdateLogAppStatus(r.Context(), id, req.Status); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := h.svc.UpdateLogAppStatus(r.Context(), id, req.Status, req.Reason); err != nil {
+		writeError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) appHistory(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	list, err := h.svc.LogAppHistory(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, list)
+}
+
 func (h *Handler) createRoute(w http.ResponseWriter, r *http.Request) {
 	var req CreateRouteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -70,7 +90,7 @@ func (h *Handler) createRoute(w http.ResponseWriter, r *http.Request) {
 	}
 	route, err := h.svc.CreateRoute(r.Context(), req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
 	respondJSON(w, http.StatusCreated, route)
@@ -80,7 +100,7 @@ func (h *Handler) assign(w http.ResponseWriter, r *http.Request) {
 	routeID, _ := strconv.ParseInt(mux.Vars(r)["routeId"], 10, 64)
 	appID, _ := strconv.ParseInt(mux.Vars(r)["applicationId"], 10, 64) // office app id
 	if err := h.svc.AssignApp(r.Context(), routeID, appID); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -89,12 +109,28 @@ func (h *Handler) assign(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) sendRoute(w http.ResponseWriter, r *http.Request) {
 	routeID, _ := strconv.ParseInt(mux.Vars(r)["routeId"], 10, 64)
 	if err := h.svc.SendRoute(r.Context(), routeID); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// writeError — чужие заявки и маршруты неотличимы от несуществующих (404);
+// запрещённый переход статуса и гонка — 409
+func writeError(w http.ResponseWriter, err error) {
+	var te *appstatus.TransitionError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, ErrNoPoint), errors.Is(err, ErrOutOfScope):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.As(err, &te), errors.Is(err, appstatus.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
 func respondJSON(w http.ResponseWriter, code int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -0,0 +1,31 @@
+package logistic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"template/internal/appstatus"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{ErrNotFound, http.StatusNotFound},
+		{ErrNoPoint, http.StatusForbidden},
+		{ErrOutOfScope, http.StatusForbidden},
+		{appstatus.Check(appstatus.Delivered, appstatus.Cancelled), http.StatusConflict},
+		{appstatus.ErrConflict, http.StatusConflict},
+		{appstatus.Check(appstatus.New, "LOST"), http.StatusBadRequest},
+		{appstatus.ErrReasonTooLong, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writeError(w, tt.err)
+		if w.Code != tt.code {
+			t.Errorf("%v: got %d, want %d", tt.err, w.Code, tt.code)
+		}
+	}
+}
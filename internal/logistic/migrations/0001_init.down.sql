@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS route_applications;
+DROP TABLE IF EXISTS route_points;
+DROP TABLE IF EXISTS routes;
+DROP TABLE IF EXISTS logistics_applications;
+DROP TYPE IF EXISTS route_status;
+DROP TYPE IF EXISTS application_status;
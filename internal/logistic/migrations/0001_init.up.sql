@@ -0,0 +1,58 @@
+-- базовая схема logistic (то, что раньше создавал EnsureSchema)
+DO $$ BEGIN
+  CREATE TYPE application_status AS ENUM ('NEW','IN_PROGRESS','SHIPPED','DELIVERED','CANCELLED');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+DO $$ BEGIN
+  CREATE TYPE route_status AS ENUM ('DRAFT','SCHEDULED','IN_PROGRESS','COMPLETED');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE TABLE IF NOT EXISTS logistics_applications (
+  id BIGSERIAL PRIMARY KEY,
+  original_application_id BIGINT NOT NULL,
+  status application_status NOT NULL DEFAULT 'NEW',
+  created_by_manager_id BIGINT NOT NULL,
+  updated_by_manager_id BIGINT,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+-- базы, созданные до миграций
+ALTER TABLE logistics_applications ADD COLUMN IF NOT EXISTS created_by_manager_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE logistics_applications ALTER COLUMN created_by_manager_id DROP DEFAULT;
+ALTER TABLE logistics_applications ADD COLUMN IF NOT EXISTS updated_by_manager_id BIGINT;
+CREATE UNIQUE INDEX IF NOT EXISTS ux_log_app_original ON logistics_applications(original_application_id);
+
+CREATE TABLE IF NOT EXISTS routes (
+  id BIGSERIAL PRIMARY KEY,
+  truck_volume NUMERIC(10,2) NOT NULL,
+  truck_max_weight NUMERIC(10,2) NOT NULL,
+  departure_date TIMESTAMP NOT NULL,
+  status route_status NOT NULL DEFAULT 'DRAFT',
+  created_by_manager_id BIGINT NOT NULL,
+  updated_by_manager_id BIGINT,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ALTER TABLE routes ALTER COLUMN created_by_manager_id DROP DEFAULT;
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS updated_by_manager_id BIGINT;
+
+CREATE TABLE IF NOT EXISTS route_points (
+  id BIGSERIAL PRIMARY KEY,
+  route_id BIGINT NOT NULL REFERENCES routes(id) ON DELETE CASCADE,
+  logistics_point_id BIGINT NOT NULL,
+  point_order INTEGER NOT NULL,
+  planned_arrival TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS route_applications (
+  id BIGSERIAL PRIMARY KEY,
+  route_id BIGINT NOT NULL REFERENCES routes(id) ON DELETE CASCADE,
+  application_id BIGINT NOT NULL,             -- office application id
+  logistic_application_id BIGINT REFERENCES logistics_applications(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_route_points_route ON route_points(route_id);
+CREATE INDEX IF NOT EXISTS idx_route_points_point ON route_points(logistics_point_id);
+CREATE INDEX IF NOT EXISTS idx_route_apps_route ON route_applications(route_id);
+CREATE INDEX IF NOT EXISTS idx_route_apps_log_app ON route_applications(logistic_application_id);
@@ -0,0 +1,12 @@
+-- история статусов заявки логистики, как application_status_history в office
+CREATE TABLE logistics_application_status_history (
+  id BIGSERIAL PRIMARY KEY,
+  logistic_application_id BIGINT NOT NULL REFERENCES logistics_applications(id) ON DELETE CASCADE,
+  from_status application_status,
+  to_status application_status NOT NULL,
+  actor_manager_id BIGINT,
+  source TEXT NOT NULL,
+  reason TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_log_app_status_history_app ON logistics_application_status_history(logistic_application_id, id);
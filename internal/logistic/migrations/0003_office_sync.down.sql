@@ -0,0 +1 @@
+DROP TABLE IF EXISTS office_status_outbox;
@@ -0,0 +1,18 @@
+-- смены статусов для передачи в office: пишутся вместе со сменой статуса,
+-- отправляются фоном с повторами (см. OfficeSync)
+CREATE TABLE office_status_outbox (
+  id BIGSERIAL PRIMARY KEY,
+  application_id BIGINT NOT NULL, -- заявка office
+  logistic_application_id BIGINT NOT NULL REFERENCES logistics_applications(id) ON DELETE CASCADE,
+  status application_status NOT NULL,
+  reason TEXT,
+  actor_manager_id BIGINT,
+  attempts INT NOT NULL DEFAULT 0,
+  last_error TEXT,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  sent_at TIMESTAMPTZ,
+  failed_at TIMESTAMPTZ -- office отказал окончательно, истории разошлись
+);
+CREATE INDEX idx_office_outbox_pending ON office_status_outbox(next_attempt_at) WHERE sent_at IS NULL AND failed_at IS NULL;
+CREATE INDEX idx_office_outbox_app ON office_status_outbox(application_id, id);
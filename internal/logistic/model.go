@@ -1,21 +1,28 @@
 package logistic
 
-import "time"
+import (
+	"time"
 
-type ApplicationStatus string
+	"template/internal/appstatus"
+)
+
+// ApplicationStatus — статус заявки; переходы общие с office, см. appstatus
+type ApplicationStatus = appstatus.Status
 
 const (
-	StatusNew        ApplicationStatus = "NEW"
-	StatusInProgress ApplicationStatus = "IN_PROGRESS"
-	StatusShipped    ApplicationStatus = "SHIPPED"
-	StatusDelivered  ApplicationStatus = "DELIVERED"
-	StatusCancelled  ApplicationStatus = "CANCELLED"
+	StatusNew        = appstatus.New
+	StatusInProgress = appstatus.InProgress
+	StatusShipped    = appstatus.Shipped
+	StatusDelivered  = appstatus.Delivered
+	StatusCancelled  = appstatus.Cancelled
 )
 
 type LogisticApplication struct {
 	ID                    int64             `json:"id"`
 	OriginalApplicationID int64             `json:"original_application_id"`
 	Status                ApplicationStatus `json:"status"`
+	CreatedByManager      int64             `json:"created_by_manager_id"`
+	UpdatedByManager      *int64            `json:"updated_by_manager_id,omitempty"`
 	CreatedAt             time.Time         `json:"created_at"`
 	UpdatedAt             time.Time         `json:"updated_at"`
 }
@@ -36,6 +43,7 @@ type Route struct {
 	DepartureDate    time.Time   `json:"departure_date"`
 	Status           RouteStatus `json:"status"`
 	CreatedByManager int64       `json:"created_by_manager_id"`
+	UpdatedByManager *int64      `json:"updated_by_manager_id,omitempty"`
 	CreatedAt        time.Time   `json:"created_at"`
 	UpdatedAt        time.Time   `json:"updated_at"`
 }
@@ -61,6 +69,29 @@ type RoutePointInput struct {
 	PlannedArrival   time.Time `json:"planned_arrival"`
 }
 
+// StatusChange — запись истории статусов заявки логистики
+type StatusChange struct {
+	ID                    int64              `json:"id"`
+	LogisticApplicationID int64              `json:"logistic_application_id"`
+	FromStatus            *ApplicationStatus `json:"from_status"` // nil — создание заявки
+	ToStatus              ApplicationStatus  `json:"to_status"`
+	ActorManagerID        *int64             `json:"actor_manager_id,omitempty"`
+	Source                string             `json:"source"`
+	Reason                *string            `json:"reason,omitempty"`
+	CreatedAt             time.Time          `json:"created_at"`
+}
+
 type UpdateStatusRequest struct {
 	Status ApplicationStatus `json:"status"`
+	Reason string            `json:"reason,omitempty"`
+}
+
+// OfficeStatusSync — смена статуса в очереди передачи в office
+type OfficeStatusSync struct {
+	ID             int64
+	ApplicationID  int64 // заявка office
+	Status         ApplicationStatus
+	Reason         *string
+	ActorManagerID *int64
+	Attempts       int // сколько попыток уже было
 }
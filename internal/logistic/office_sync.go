@@ -0,0 +1,141 @@
+package logistic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	officeSyncBatch    = 100
+	officeSyncRetry    = 5 * time.Second // первая пауза перед повтором, дальше удваивается
+	officeSyncMaxDelay = time.Hour
+)
+
+// OfficeSync — передача смен статусов во внутренний API office из очереди
+// office_status_outbox. Очередь пишется вместе со сменой статуса, так что
+// недоступность office смену не теряет: временные ошибки повторяются с растущей
+// паузой, отказ office (4xx) остаётся в очереди с failed_at и last_error.
+type OfficeSync struct {
+	repo    Repo
+	baseURL string
+	client  *http.Client // подставляет токен сервиса
+	wake    chan struct{}
+}
+
+func NewOfficeSync(repo Repo, officeInternalBaseURL string, client *http.Client) *OfficeSync {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &OfficeSync{
+		repo:    repo,
+		baseURL: strings.TrimRight(officeInternalBaseURL, "/"),
+		client:  client,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Wake — в очереди появились смены; не ждёт отправки
+func (o *OfficeSync) Wake() {
+	if o == nil {
+		return
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run — отправляет очередь по Wake и раз в every (повторы после ошибок)
+func (o *OfficeSync) Run(every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		// за проход по каждой заявке уходит одна смена — следующая уйдёт в следующем;
+		// если исход ни одной не записан (ошибка БД), ждём тика, а не крутимся
+		for o.flush() > 0 {
+		}
+		select {
+		case <-o.wake:
+		case <-t.C:
+		}
+	}
+}
+
+// flush — одна выборка очереди; возвращает, у скольких смен записан исход
+// (отправлена или отложена до повтора)
+func (o *OfficeSync) flush() int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	list, err := o.repo.PendingOfficeSync(ctx, officeSyncBatch)
+	if err != nil {
+		log.Printf("[logistic] office sync: %v", err)
+		return 0
+	}
+	done := 0
+	for _, e := range list {
+		permanent, err := o.send(ctx, e)
+		if err == nil {
+			if err := o.repo.OfficeSyncSent(ctx, e.ID); err != nil {
+				log.Printf("[logistic] office sync %d: %v", e.ID, err)
+				continue
+			}
+			done++
+			continue
+		}
+		var retryAt *time.Time
+		if !permanent {
+			at := time.Now().Add(min(officeSyncRetry<<min(e.Attempts, 10), officeSyncMaxDelay))
+			retryAt = &at
+		}
+		log.Printf("[logistic] office sync app=%d status=%s attempt=%d: %v (retry=%t)", e.ApplicationID, e.Status, e.Attempts+1, err, !permanent)
+		if err := o.repo.OfficeSyncFailed(ctx, e.ID, err.Error(), retryAt); err != nil {
+			log.Printf("[logistic] office sync %d: %v", e.ID, err)
+			continue
+		}
+		done++
+	}
+	return done
+}
+
+// officeStatusRequest — тело POST /internal/office/applications/{id}/status
+type officeStatusRequest struct {
+	Status         ApplicationStatus `json:"status"`
+	Reason         string            `json:"reason,omitempty"`
+	ActorManagerID *int64            `json:"actor_manager_id,omitempty"`
+}
+
+// send — одна попытка; permanent — office отказал по существу (4xx), повтор не поможет
+func (o *OfficeSync) send(ctx context.Context, e OfficeStatusSync) (permanent bool, err error) {
+	in := officeStatusRequest{Status: e.Status, ActorManagerID: e.ActorManagerID}
+	if e.Reason != nil {
+		in.Reason = *e.Reason
+	}
+	body, _ := json.Marshal(in)
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		o.baseURL+"/internal/office/applications/"+strconv.FormatInt(e.ApplicationID, 10)+"/status",
+		strings.NewReader(string(body)),
+	)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		switch resp.StatusCode {
+		case http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity:
+			permanent = true
+		}
+		return permanent, fmt.Errorf("office: %s", resp.Status)
+	}
+	return false, nil
+}
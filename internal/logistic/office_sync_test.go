@@ -0,0 +1,60 @@
+package logistic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// syncRepo — очередь office_status_outbox в памяти; остальные методы Repo не нужны
+type syncRepo struct {
+	Repo
+	pending []OfficeStatusSync
+	markErr error
+	sent    int
+	failed  int
+}
+
+func (r *syncRepo) PendingOfficeSync(context.Context, int) ([]OfficeStatusSync, error) {
+	return r.pending, nil
+}
+
+func (r *syncRepo) OfficeSyncSent(context.Context, int64) error {
+	if r.markErr != nil {
+		return r.markErr
+	}
+	r.sent++
+	return nil
+}
+
+func (r *syncRepo) OfficeSyncFailed(context.Context, int64, string, *time.Time) error {
+	if r.markErr != nil {
+		return r.markErr
+	}
+	r.failed++
+	return nil
+}
+
+func TestOfficeSyncFlushProgress(t *testing.T) {
+	for _, code := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		office := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		pending := []OfficeStatusSync{{ID: 1, ApplicationID: 10, Status: StatusShipped}, {ID: 2, ApplicationID: 11, Status: StatusShipped}}
+
+		repo := &syncRepo{pending: pending}
+		if n := NewOfficeSync(repo, office.URL, nil).flush(); n != 2 || repo.sent+repo.failed != 2 {
+			t.Errorf("office %d: flush = %d, sent %d, failed %d", code, n, repo.sent, repo.failed)
+		}
+
+		// исход не записать — прогресса нет, Run не должен крутиться вхолостую
+		repo = &syncRepo{pending: pending, markErr: errors.New("db down")}
+		if n := NewOfficeSync(repo, office.URL, nil).flush(); n != 0 {
+			t.Errorf("office %d with failing repo: flush = %d, want 0", code, n)
+		}
+		office.Close()
+	}
+}
@@ -3,100 +3,131 @@ package logistic
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"time"
+
+	"template/internal/appstatus"
 )
 
 type Repo interface {
-	EnsureSchema(ctx context.Context) error
-
-	GetLogApp(ctx context.Context, id int64) (LogisticApplication, error)
-	FindOrCreateLogApp(ctx context.Context, originalID int64) (int64, error)
-	UpdateLogAppStatus(ctx context.Context, id int64, status ApplicationStatus) error
-	ListLogApps(ctx context.Context, status *string) ([]LogisticApplication, error)
-
-	InsertRoute(ctx context.Context, r CreateRouteRequest) (Route, error)
+	// pointID — ограничение логточкой менеджера (nil — без ограничения): видны
+	// только заявки на маршрутах и маршруты, проходящие через эту точку
+	GetLogApp(ctx context.Context, id int64, pointID *int64) (LogisticApplication, error)
+	FindOrCreateLogApp(ctx context.Context, originalID, createdBy int64) (int64, error)
+	// UpdateLogAppStatus меняет статус на ch.ToStatus, только если он всё ещё from
+	// (иначе appstatus.ErrConflict), и в той же транзакции пишет ch в историю и
+	// в очередь передачи в office
+	UpdateLogAppStatus(ctx context.Context, id int64, from ApplicationStatus, ch StatusChange) error
+	LogAppHistory(ctx context.Context, id int64) ([]StatusChange, error)
+	ListLogApps(ctx context.Context, status *string, pointID *int64) ([]LogisticApplication, error)
+
+	InsertRoute(ctx context.Context, r CreateRouteRequest, createdBy int64) (Route, error)
 	InsertRoutePoint(ctx context.Context, routeID int64, p RoutePointInput) error
-	AssignRouteApp(ctx context.Context, routeID, originalAppID, logAppID int64) error
+	// RouteInScope — маршрут существует и (если pointID задан) проходит через точку
+	RouteInScope(ctx context.Context, routeID int64, pointID *int64) (bool, error)
+	AssignRouteApp(ctx context.Context, routeID, originalAppID, logAppID int64, pointID *int64) error
 	RouteAppPairs(ctx context.Context, routeID int64) ([][2]int64, error)
-	SetRouteInProgress(ctx context.Context, routeID int64) error
+	SetRouteInProgress(ctx context.Context, routeID int64, updatedBy *int64) error
+
+	// PendingOfficeSync — смены для office, срок попытки которых наступил; по
+	// каждой заявке office — только самая ранняя неотправленная, чтобы статусы
+	// доходили по порядку
+	PendingOfficeSync(ctx context.Context, limit int) ([]OfficeStatusSync, error)
+	OfficeSyncSent(ctx context.Context, id int64) error
+	// OfficeSyncFailed — неудачная попытка; retryAt nil — больше не пытаться
+	OfficeSyncFailed(ctx context.Context, id int64, errText string, retryAt *time.Time) error
 }
 
 type pgRepo struct{ db *sql.DB }
 
 func NewRepo(db *sql.DB) Repo { return &pgRepo{db: db} }
 
-func (r *pgRepo) EnsureSchema(ctx context.Context) error {
-	ddl := `
-CREATE TYPE IF NOT EXISTS application_status AS ENUM ('NEW','IN_PROGRESS','SHIPPED','DELIVERED','CANCELLED');
-CREATE TYPE IF NOT EXISTS route_status AS ENUM ('DRAFT','SCHEDULED','IN_PROGRESS','COMPLETED');
-
-CREATE TABLE IF NOT EXISTS logistics_applications (
-  id BIGSERIAL PRIMARY KEY,
-  original_application_id BIGINT NOT NULL,
-  status application_status NOT NULL DEFAULT 'NEW',
-  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
-);
-CREATE UNIQUE INDEX IF NOT EXISTS ux_log_app_original ON logistics_applications(original_application_id);
-
-CREATE TABLE IF NOT EXISTS routes (
-  id BIGSERIAL PRIMARY KEY,
-  truck_volume NUMERIC(10,2) NOT NULL,
-  truck_max_weight NUMERIC(10,2) NOT NULL,
-  departure_date TIMESTAMP NOT NULL,
-  status route_status NOT NULL DEFAULT 'DRAFT',
-  created_by_manager_id BIGINT NOT NULL DEFAULT 1,
-  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
-);
-
-CREATE TABLE IF NOT EXISTS route_points (
-  id BIGSERIAL PRIMARY KEY,
-  route_id BIGINT NOT NULL REFERENCES routes(id) ON DELETE CASCADE,
-  logistics_point_id BIGINT NOT NULL,
-  point_order INTEGER NOT NULL,
-  planned_arrival TIMESTAMP NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS route_applications (
-  id BIGSERIAL PRIMARY KEY,
-  route_id BIGINT NOT NULL REFERENCES routes(id) ON DELETE CASCADE,
-  application_id BIGINT NOT NULL,             -- office application id
-  logistic_application_id BIGINT REFERENCES logistics_applications(id)
-);
-
-CREATE INDEX IF NOT EXISTS idx_route_points_route ON route_points(route_id);
-CREATE INDEX IF NOT EXISTS idx_route_apps_route ON route_applications(route_id);
-`
-	_, err := r.db.ExecContext(ctx, ddl)
-	return err
-}
+// appAtPoint — условие «заявка на маршруте через логточку $n»; при NULL — любая
+const appAtPoint = `($%[1]d::BIGINT IS NULL OR EXISTS (
+  SELECT 1 FROM route_applications ra JOIN route_points rp ON rp.route_id = ra.route_id
+  WHERE ra.logistic_application_id = logistics_applications.id AND rp.logistics_point_id = $%[1]d))`
+
+// routeAtPoint — то же для маршрута
+const routeAtPoint = `($%[1]d::BIGINT IS NULL OR EXISTS (
+  SELECT 1 FROM route_points rp WHERE rp.route_id = routes.id AND rp.logistics_point_id = $%[1]d))`
 
-func (r *pgRepo) GetLogApp(ctx context.Context, id int64) (LogisticApplication, error) {
-	row := r.db.QueryRowContext(ctx, `SELECT id, original_application_id, status, created_at, updated_at FROM logistics_applications WHERE id=$1`, id)
+func (r *pgRepo) GetLogApp(ctx context.Context, id int64, pointID *int64) (LogisticApplication, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT id, original_application_id, status, created_by_manager_id, updated_by_manager_id, created_at, updated_at
+FROM logistics_applications WHERE id=$1 AND `+fmt.Sprintf(appAtPoint, 2), id, pointID)
 	var app LogisticApplication
-	err := row.Scan(&app.ID, &app.OriginalApplicationID, &app.Status, &app.CreatedAt, &app.UpdatedAt)
+	err := row.Scan(&app.ID, &app.OriginalApplicationID, &app.Status, &app.CreatedByManager, &app.UpdatedByManager, &app.CreatedAt, &app.UpdatedAt)
 	return app, err
 }
 
-func (r *pgRepo) FindOrCreateLogApp(ctx context.Context, originalID int64) (int64, error) {
+func (r *pgRepo) FindOrCreateLogApp(ctx context.Context, originalID, createdBy int64) (int64, error) {
 	var id int64
 	err := r.db.QueryRowContext(ctx, `SELECT id FROM logistics_applications WHERE original_application_id=$1`, originalID).Scan(&id)
 	if err == sql.ErrNoRows {
-		err = r.db.QueryRowContext(ctx, `INSERT INTO logistics_applications(original_application_id,status) VALUES($1,'NEW') RETURNING id`, originalID).Scan(&id)
+		err = r.db.QueryRowContext(ctx, `
+WITH app AS (
+  INSERT INTO logistics_applications(original_application_id,status,created_by_manager_id) VALUES($1,'NEW',$2) RETURNING id, status
+), hist AS (
+  INSERT INTO logistics_application_status_history(logistic_application_id, to_status, actor_manager_id, source)
+  SELECT id, status, $2, $3 FROM app
+)
+SELECT id FROM app`,
+			originalID, createdBy, sourceLogistic).Scan(&id)
 	}
 	return id, err
 }
 
-func (r *pgRepo) UpdateLogAppStatus(ctx context.Context, id int64, status ApplicationStatus) error {
-	_, err := r.db.ExecContext(ctx, `UPDATE logistics_applications SET status=$1, updated_at=NOW() WHERE id=$2`, status, id)
-	return err
+// sourceLogistic — источник записей истории в logistic
+const sourceLogistic = "logistic"
+
+func (r *pgRepo) UpdateLogAppStatus(ctx context.Context, id int64, from ApplicationStatus, ch StatusChange) error {
+	// один оператор — смена статуса, запись истории и очередь для office атомарны
+	res, err := r.db.ExecContext(ctx, `
+WITH upd AS (
+  UPDATE logistics_applications SET status=$1, updated_by_manager_id=$2, updated_at=NOW()
+  WHERE id=$3 AND status=$4
+  RETURNING id, original_application_id
+), hist AS (
+  INSERT INTO logistics_application_status_history (logistic_application_id, from_status, to_status, actor_manager_id, source, reason)
+  SELECT id, $4, $1, $2, $5, $6 FROM upd
+)
+INSERT INTO office_status_outbox (application_id, logistic_application_id, status, reason, actor_manager_id)
+SELECT original_application_id, id, $1, $6, $2 FROM upd`,
+		ch.ToStatus, ch.ActorManagerID, id, from, sourceLogistic, ch.Reason)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return appstatus.ErrConflict
+	}
+	return nil
+}
+
+func (r *pgRepo) LogAppHistory(ctx context.Context, id int64) ([]StatusChange, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, logistic_application_id, from_status, to_status, actor_manager_id, source, reason, created_at
+FROM logistics_application_status_history WHERE logistic_application_id=$1 ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []StatusChange{}
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.ID, &c.LogisticApplicationID, &c.FromStatus, &c.ToStatus,
+			&c.ActorManagerID, &c.Source, &c.Reason, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
 }
 
-func (r *pgRepo) ListLogApps(ctx context.Context, status *string) ([]LogisticApplication, error) {
-	q := `SELECT id, original_application_id, status, created_at, updated_at FROM logistics_applications`
-	args := []any{}
+func (r *pgRepo) ListLogApps(ctx context.Context, status *string, pointID *int64) ([]LogisticApplication, error) {
+	q := `SELECT id, original_application_id, status, created_by_manager_id, updated_by_manager_id, created_at, updated_at FROM logistics_applications
+WHERE ` + fmt.Sprintf(appAtPoint, 1)
+	args := []any{pointID}
 	if status != nil && *status != "" {
-		q += " WHERE status=$1"
+		q += " AND status=$2"
 		args = append(args, *status)
 	}
 	q += " ORDER BY id DESC LIMIT 100"
@@ -108,7 +139,7 @@ func (r *pgRepo) ListLogApps(ctx context.Context, status *string) ([]LogisticApp
 	var list []LogisticApplication
 	for rows.Next() {
 		var a LogisticApplication
-		if err := rows.Scan(&a.ID, &a.OriginalApplicationID, &a.Status, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		if err := rows.Scan(&a.ID, &a.OriginalApplicationID, &a.Status, &a.CreatedByManager, &a.UpdatedByManager, &a.CreatedAt, &a.UpdatedAt); err != nil {
 			return nil, err
 		}
 		list = append(list, a)
@@ -116,12 +147,12 @@ func (r *pgRepo) ListLogApps(ctx context.Context, status *string) ([]LogisticApp
 	return list, nil
 }
 
-func (r *pgRepo) InsertRoute(ctx context.Context, req CreateRouteRequest) (Route, error) {
-	row := r.db.QueryRowContext(ctx, `INSERT INTO routes(truck_volume,truck_max_weight,departure_date,status) VALUES($1,$2,$3,'DRAFT')
-	RETURNING id,truck_volume,truck_max_weight,departure_date,status,created_by_manager_id,created_at,updated_at`,
-		req.TruckVolume, req.TruckMaxWeight, req.DepartureDate)
+func (r *pgRepo) InsertRoute(ctx context.Context, req CreateRouteRequest, createdBy int64) (Route, error) {
+	row := r.db.QueryRowContext(ctx, `INSERT INTO routes(truck_volume,truck_max_weight,departure_date,status,created_by_manager_id) VALUES($1,$2,$3,'DRAFT',$4)
+	RETURNING id,truck_volume,truck_max_weight,departure_date,status,created_by_manager_id,updated_by_manager_id,created_at,updated_at`,
+		req.TruckVolume, req.TruckMaxWeight, req.DepartureDate, createdBy)
 	var rt Route
-	err := row.Scan(&rt.ID, &rt.TruckVolume, &rt.TruckMaxWeight, &rt.DepartureDate, &rt.Status, &rt.CreatedByManager, &rt.CreatedAt, &rt.UpdatedAt)
+	err := row.Scan(&rt.ID, &rt.TruckVolume, &rt.TruckMaxWeight, &rt.DepartureDate, &rt.Status, &rt.CreatedByManager, &rt.UpdatedByManager, &rt.CreatedAt, &rt.UpdatedAt)
 	return rt, err
 }
 
@@ -131,10 +162,25 @@ func (r *pgRepo) InsertRoutePoint(ctx context.Context, routeID int64, p RoutePoi
 	return err
 }
 
-func (r *pgRepo) AssignRouteApp(ctx context.Context, routeID, originalAppID, logAppID int64) error {
-	_, err := r.db.ExecContext(ctx, `INSERT INTO route_applications(route_id,application_id,logistic_application_id) VALUES($1,$2,$3)`,
-		routeID, originalAppID, logAppID)
-	return err
+func (r *pgRepo) RouteInScope(ctx context.Context, routeID int64, pointID *int64) (bool, error) {
+	var ok bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM routes WHERE id=$1 AND `+fmt.Sprintf(routeAtPoint, 2)+`)`,
+		routeID, pointID).Scan(&ok)
+	return ok, err
+}
+
+// AssignRouteApp — вставка только если маршрут в области видимости, иначе ErrNotFound
+func (r *pgRepo) AssignRouteApp(ctx context.Context, routeID, originalAppID, logAppID int64, pointID *int64) error {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO route_applications(route_id,application_id,logistic_application_id)
+SELECT id, $2, $3 FROM routes WHERE id=$1 AND `+fmt.Sprintf(routeAtPoint, 4),
+		routeID, originalAppID, logAppID, pointID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
 func (r *pgRepo) RouteAppPairs(ctx context.Context, routeID int64) ([][2]int64, error) {
@@ -154,7 +200,45 @@ func (r *pgRepo) RouteAppPairs(ctx context.Context, routeID int64) ([][2]int64,
 	return out, nil
 }
 
-func (r *pgRepo) SetRouteInProgress(ctx context.Context, routeID int64) error {
-	_, err := r.db.ExecContext(ctx, `UPDATE routes SET status='IN_PROGRESS', updated_at=NOW() WHERE id=$1`, routeID)
+func (r *pgRepo) SetRouteInProgress(ctx context.Context, routeID int64, updatedBy *int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE routes SET status='IN_PROGRESS', updated_by_manager_id=$1, updated_at=NOW() WHERE id=$2`, updatedBy, routeID)
+	return err
+}
+
+func (r *pgRepo) PendingOfficeSync(ctx context.Context, limit int) ([]OfficeStatusSync, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT o.id, o.application_id, o.status, o.reason, o.actor_manager_id, o.attempts
+FROM office_status_outbox o
+WHERE o.sent_at IS NULL AND o.failed_at IS NULL AND o.next_attempt_at <= NOW()
+  AND NOT EXISTS (
+    SELECT 1 FROM office_status_outbox p
+    WHERE p.application_id = o.application_id AND p.id < o.id AND p.sent_at IS NULL AND p.failed_at IS NULL)
+ORDER BY o.id LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []OfficeStatusSync
+	for rows.Next() {
+		var e OfficeStatusSync
+		if err := rows.Scan(&e.ID, &e.ApplicationID, &e.Status, &e.Reason, &e.ActorManagerID, &e.Attempts); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (r *pgRepo) OfficeSyncSent(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE office_status_outbox SET sent_at=NOW(), attempts=attempts+1, last_error=NULL WHERE id=$1`, id)
+	return err
+}
+
+func (r *pgRepo) OfficeSyncFailed(ctx context.Context, id int64, errText string, retryAt *time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+UPDATE office_status_outbox SET attempts=attempts+1, last_error=$2,
+  next_attempt_at=COALESCE($3, next_attempt_at),
+  failed_at=CASE WHEN $3::TIMESTAMPTZ IS NULL THEN NOW() END
+WHERE id=$1`, id, errText, retryAt)
 	return err
 }
@@ -1,32 +1,35 @@
 package logistic
 
 import (
-	"context"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"template/internal/db"
 	"template/internal/middleware/authmw"
+	"template/internal/migrate"
+	"template/internal/svcauth"
 )
 
-func Start(port, dsn, officeInternalURL, jwtSecret string) {
+func Start(port, dsn, officeInternalURL, jwksURL, revocationsURL, tokenURL, clientID, clientSecret string) {
 	dbc := db.MustConnect(dsn)
+	migrate.MustUp(dbc, "logistic", Migrations())
 	repo := NewRepo(dbc)
-	if err := repo.EnsureSchema(context.Background()); err != nil {
-		log.Fatalf("logistic ensure schema: %v", err)
-	}
-	svc, _ := NewService(repo, officeInternalURL)
+	// вызовы office и auth — от имени сервиса, с токеном client credentials
+	svcClient := svcauth.NewTokenSource(tokenURL, clientID, clientSecret).Client()
+	office := NewOfficeSync(repo, officeInternalURL, svcClient)
+	go office.Run(10 * time.Second)
+	svc, _ := NewService(repo, office)
 
 	r := mux.NewRouter()
 	logRouter := r.PathPrefix("/logistic").Subrouter()
 
-	// доступ только менеджеру логистической точки
-	mw := authmw.New(jwtSecret)
-	logRouter.Use(mw.RequireRoles("logistics_manager"))
+	// доступ — по разрешениям в токене, см. Handler.Register
+	mw := authmw.New(authmw.NewRemoteJWKS(jwksURL, 5*time.Minute), authmw.NewRemoteRevocations(revocationsURL, svcClient, 15*time.Second))
 
-	NewHandler(svc).Register(logRouter)
+	NewHandler(svc, mw).Register(logRouter)
 
 	log.Printf("[logistic] :%s (dsn=%s, officeCB=%s)", port, dsn, officeInternalURL)
 	log.Fatal(http.ListenAndServe(":"+port, r))
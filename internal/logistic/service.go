@@ -2,17 +2,27 @@ package logistic
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
 	"errors"
-	"net/http"
-	"strconv"
-	"strings"
+	"fmt"
+	"log"
+	"slices"
+
+	"template/internal/appstatus"
+	"template/internal/middleware/authmw"
+)
+
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrNoPoint    = errors.New("logistics point not assigned")
+	ErrOutOfScope = errors.New("route must include your logistics point")
 )
 
 type Service interface {
 	GetLogApp(ctx context.Context, id int64) (LogisticApplication, error)
 	ListLogApps(ctx context.Context, status *string) ([]LogisticApplication, error)
-	UpdateLogAppStatus(ctx context.Context, id int64, status ApplicationStatus) error
+	UpdateLogAppStatus(ctx context.Context, id int64, status ApplicationStatus, reason string) error
+	LogAppHistory(ctx context.Context, id int64) ([]StatusChange, error)
 
 	CreateRoute(ctx context.Context, req CreateRouteRequest) (Route, error)
 	AssignApp(ctx context.Context, routeID, originalAppID int64) error
@@ -20,50 +30,89 @@ type Service interface {
 }
 
 type service struct {
-	repo     Repo
-	officeCB string
+	repo   Repo
+	office *OfficeSync // nil — смены остаются в очереди до запуска OfficeSync
 }
 
-func NewService(repo Repo, officeInternalBaseURL string) (Service, error) {
+func NewService(repo Repo, office *OfficeSync) (Service, error) {
 	if repo == nil {
 		return nil, errors.New("nil repo")
 	}
-	return &service{repo: repo, officeCB: strings.TrimRight(officeInternalBaseURL, "/")}, nil
+	return &service{repo: repo, office: office}, nil
 }
 
 func (s *service) GetLogApp(ctx context.Context, id int64) (LogisticApplication, error) {
-	return s.repo.GetLogApp(ctx, id)
+	point, err := pointScope(ctx)
+	if err != nil {
+		return LogisticApplication{}, err
+	}
+	app, err := s.repo.GetLogApp(ctx, id, point)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LogisticApplication{}, ErrNotFound
+	}
+	return app, err
 }
 
 func (s *service) ListLogApps(ctx context.Context, status *string) ([]LogisticApplication, error) {
-	return s.repo.ListLogApps(ctx, status)
+	if status != nil && !ApplicationStatus(*status).Valid() {
+		return nil, appstatus.ErrUnknownStatus
+	}
+	point, err := pointScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.ListLogApps(ctx, status, point)
 }
 
-func (s *service) UpdateLogAppStatus(ctx context.Context, id int64, status ApplicationStatus) error {
-	if err := s.repo.UpdateLogAppStatus(ctx, id, status); err != nil {
+// UpdateLogAppStatus — только разрешённые переходы (appstatus.Check); тот же статус —
+// без изменений. Смена попадает в историю и в очередь передачи в office (с той же
+// причиной и менеджером), см. OfficeSync
+func (s *service) UpdateLogAppStatus(ctx context.Context, id int64, status ApplicationStatus, reason string) error {
+	why, err := appstatus.Reason(reason)
+	if err != nil {
 		return err
 	}
-	app, err := s.repo.GetLogApp(ctx, id)
+	app, err := s.GetLogApp(ctx, id)
 	if err != nil {
 		return err
 	}
-	body, _ := json.Marshal(struct {
-		Status ApplicationStatus `json:"status"`
-	}{Status: status})
-	req, _ := http.NewRequestWithContext(ctx, "POST",
-		s.officeCB+"/office/applications/"+strconv.FormatInt(app.OriginalApplicationID, 10)+"/status",
-		strings.NewReader(string(body)),
-	)
-	req.Header.Set("Content-Type", "application/json")
-	_, _ = http.DefaultClient.Do(req)
+	if err := appstatus.Check(app.Status, status); err != nil || app.Status == status {
+		return err
+	}
+	caller, _ := authmw.FromContext(ctx)
+	ch := StatusChange{ToStatus: status, ActorManagerID: caller.ManagerID(), Reason: why}
+	if err := s.repo.UpdateLogAppStatus(ctx, id, app.Status, ch); err != nil {
+		return err
+	}
+	s.office.Wake()
 	return nil
 }
 
+// LogAppHistory — смены статуса заявки логистики по порядку, начиная с создания
+func (s *service) LogAppHistory(ctx context.Context, id int64) ([]StatusChange, error) {
+	if _, err := s.GetLogApp(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.repo.LogAppHistory(ctx, id)
+}
+
 func (s *service) CreateRoute(ctx context.Context, req CreateRouteRequest) (Route, error) {
 	if req.TruckVolume <= 0 || req.TruckMaxWeight <= 0 || len(req.RoutePoints) < 2 {
 		return Route{}, errors.New("invalid route data")
 	}
-	route, err := s.repo.InsertRoute(ctx, req)
+	caller, _ := authmw.FromContext(ctx)
+	creator := caller.ManagerID()
+	if creator == nil {
+		return Route{}, errors.New("manager required")
+	}
+	point, err := pointScope(ctx)
+	if err != nil {
+		return Route{}, err
+	}
+	if point != nil && !slices.ContainsFunc(req.RoutePoints, func(p RoutePointInput) bool { return p.LogisticsPointID == *point }) {
+		return Route{}, ErrOutOfScope
+	}
+	route, err := s.repo.InsertRoute(ctx, req, *creator)
 	if err != nil {
 		return Route{}, err
 	}
@@ -76,34 +125,75 @@ func (s *service) CreateRoute(ctx context.Context, req CreateRouteRequest) (Rout
 }
 
 func (s *service) AssignApp(ctx context.Context, routeID, originalAppID int64) error {
-	logAppID, err := s.repo.FindOrCreateLogApp(ctx, originalAppID)
+	caller, _ := authmw.FromContext(ctx)
+	creator := caller.ManagerID()
+	if creator == nil {
+		return errors.New("manager required")
+	}
+	point, err := s.routeScope(ctx, routeID)
+	if err != nil {
+		return err
+	}
+	logAppID, err := s.repo.FindOrCreateLogApp(ctx, originalAppID, *creator)
 	if err != nil {
 		return err
 	}
-	return s.repo.AssignRouteApp(ctx, routeID, originalAppID, logAppID)
+	return s.repo.AssignRouteApp(ctx, routeID, originalAppID, logAppID, point)
 }
 
 func (s *service) SendRoute(ctx context.Context, routeID int64) error {
-	if err := s.repo.SetRouteInProgress(ctx, routeID); err != nil {
+	if _, err := s.routeScope(ctx, routeID); err != nil {
+		return err
+	}
+	caller, _ := authmw.FromContext(ctx)
+	actor := caller.ManagerID()
+	if err := s.repo.SetRouteInProgress(ctx, routeID, actor); err != nil {
 		return err
 	}
 	pairs, err := s.repo.RouteAppPairs(ctx, routeID)
 	if err != nil {
 		return err
 	}
+	reason := fmt.Sprintf("route %d sent", routeID)
+	ch := StatusChange{ToStatus: StatusInProgress, ActorManagerID: actor, Reason: &reason}
 	for _, p := range pairs {
-		logID, officeID := p[0], p[1]
-		_ = s.repo.UpdateLogAppStatus(ctx, logID, StatusInProgress)
-
-		body, _ := json.Marshal(struct {
-			Status ApplicationStatus `json:"status"`
-		}{Status: StatusInProgress})
-		req, _ := http.NewRequestWithContext(ctx, "POST",
-			s.officeCB+"/office/applications/"+strconv.FormatInt(officeID, 10)+"/status",
-			strings.NewReader(string(body)),
-		)
-		req.Header.Set("Content-Type", "application/json")
-		_, _ = http.DefaultClient.Do(req)
+		logID := p[0]
+		// в работу уходят только новые заявки; отменённые и уже отправленные не трогаем
+		if err := s.repo.UpdateLogAppStatus(ctx, logID, StatusNew, ch); err != nil {
+			if !errors.Is(err, appstatus.ErrConflict) {
+				log.Printf("[logistic] route %d app=%d: %v", routeID, logID, err)
+			}
+		}
 	}
+	s.office.Wake()
 	return nil
 }
+
+// pointScope — логточка, которой ограничен менеджер логистики; nil — без
+// ограничения (администратор, сервисы)
+func pointScope(ctx context.Context) (*int64, error) {
+	p, _ := authmw.FromContext(ctx)
+	if p.LogisticsPointID != nil {
+		return p.LogisticsPointID, nil
+	}
+	if p.Role == authmw.RoleLogisticsManager {
+		return nil, ErrNoPoint
+	}
+	return nil, nil
+}
+
+// routeScope — маршрут виден текущему пользователю; чужой — как несуществующий
+func (s *service) routeScope(ctx context.Context, routeID int64) (*int64, error) {
+	point, err := pointScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.repo.RouteInScope(ctx, routeID, point)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return point, nil
+}
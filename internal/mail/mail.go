@@ -0,0 +1,127 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message — простое текстовое письмо
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer — отправка писем; реализации — SMTP и File (для разработки и тестов)
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+var ErrBadHeader = errors.New("mail: newline in header")
+
+// New — SMTP, если задан адрес сервера, иначе File, если явно задан file ("-" — stdout);
+// без того и другого nil: письма не отправляются (и ссылки сброса не попадают в логи)
+func New(smtpAddr, user, password, from, file string) Mailer {
+	switch {
+	case smtpAddr != "":
+		return NewSMTP(smtpAddr, user, password, from)
+	case file != "":
+		return NewFile(file, from)
+	}
+	return nil
+}
+
+// SMTP — отправка через SMTP-сервер (STARTTLS, если сервер его предлагает)
+type SMTP struct {
+	addr     string
+	from     string
+	envelope string    // адрес отправителя без имени, для MAIL FROM
+	auth     smtp.Auth // nil — без авторизации
+}
+
+func NewSMTP(addr, user, password, from string) *SMTP {
+	s := &SMTP{addr: addr, from: from, envelope: from}
+	if a, err := netmail.ParseAddress(from); err == nil {
+		s.envelope = a.Address
+	}
+	if user != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		s.auth = smtp.PlainAuth("", user, password, host)
+	}
+	return s
+}
+
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	msg, err := render(s.from, m)
+	if err != nil {
+		return err
+	}
+	// net/smtp не умеет контекст — ограничиваем отправку отдельной горутиной
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.addr, s.auth, s.envelope, []string{m.To}, msg) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// File — письма целиком дописываются в файл или в stdout (путь пуст или "-")
+type File struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+func NewFile(path, from string) *File { return &File{path: path, from: from} }
+
+func (f *File) Send(_ context.Context, m Message) error {
+	msg, err := render(f.from, m)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var w io.Writer = os.Stdout
+	if f.path != "" && f.path != "-" {
+		file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	_, err = fmt.Fprintf(w, "%s\r\n.\r\n", msg)
+	return err
+}
+
+// render — письмо в формате RFC 5322, тема в UTF-8 кодируется по RFC 2047
+func render(from string, m Message) ([]byte, error) {
+	for _, h := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(h, "\r\n") {
+			return nil, ErrBadHeader
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	if m := New("", "", "", "a7@example.com", ""); m != nil {
+		t.Fatalf("no SMTP and no file: got %T, want nil", m)
+	}
+	if _, ok := New("", "", "", "a7@example.com", "-").(*File); !ok {
+		t.Fatal(`file "-": want *File`)
+	}
+	if _, ok := New("smtp.example.com:587", "", "", "a7@example.com", "out.eml").(*SMTP); !ok {
+		t.Fatal("SMTP address set: want *SMTP")
+	}
+}
+
+func TestFileSend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.eml")
+	f := NewFile(path, "A7 <a7@example.com>")
+	msgs := []Message{
+		{To: "bob@example.com", Subject: "Восстановление пароля", Body: "line 1\nline 2"},
+		{To: "eve@example.com", Subject: "second", Body: "x"},
+	}
+	for _, m := range msgs {
+		if err := f.Send(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(raw)
+	for _, want := range []string{
+		"From: A7 <a7@example.com>\r\n",
+		"To: bob@example.com\r\n",
+		"Subject: =?utf-8?q?",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nline 1\r\nline 2\r\n.\r\n",
+		"To: eve@example.com\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	f := NewFile(filepath.Join(t.TempDir(), "mail.eml"), "a7@example.com")
+	for _, m := range []Message{
+		{To: "bob@example.com\r\nBcc: eve@example.com", Subject: "s"},
+		{To: "bob@example.com", Subject: "s\nBcc: eve@example.com"},
+	} {
+		if err := f.Send(context.Background(), m); !errors.Is(err, ErrBadHeader) {
+			t.Errorf("%q: got %v, want ErrBadHeader", m.To+m.Subject, err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package authmw
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RoleAPIKey — роль ключей интеграций; sub — "apikey:<префикс>"
+const RoleAPIKey = "api_key"
+
+// APIKeyHeader — заголовок с ключом интеграции
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyCacheTTL — сколько сервисы помнят результат проверки ключа; отзыв
+// ключа попадает в список отзыва (jti "apikey:<id>") минимум на этот срок
+const APIKeyCacheTTL = time.Minute
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyJTI — jti, под которым ключ попадает в список отзыва
+func APIKeyJTI(id int64) string { return "apikey:" + strconv.FormatInt(id, 10) }
+
+// APIKeyInfo — ответ auth на проверку ключа
+type APIKeyInfo struct {
+	Active         bool     `json:"active"`
+	ID             int64    `json:"key_id,omitempty"`
+	Prefix         string   `json:"prefix,omitempty"`
+	OrganizationID int64    `json:"organization_id,omitempty"`
+	Permissions    []string `json:"permissions,omitempty"`
+	RateLimit      int      `json:"rate_limit,omitempty"` // запросов в минуту, 0 — без лимита
+}
+
+// Claims — представление ключа в виде claims, чтобы дальше всё работало как с токеном
+func (k APIKeyInfo) Claims() *Claims {
+	org := k.OrganizationID
+	c := &Claims{
+		Sub:            "apikey:" + k.Prefix,
+		Role:           RoleAPIKey,
+		Permissions:    k.Permissions,
+		APIKeyID:       k.ID,
+		OrganizationID: &org,
+	}
+	c.ID = APIKeyJTI(k.ID)
+	return c
+}
+
+// APIKeySource — проверка ключа интеграции
+type APIKeySource interface {
+	LookupAPIKey(ctx context.Context, key string) (APIKeyInfo, error)
+}
+
+// RemoteAPIKeys — проверка ключей через внутренний API auth
+// (/internal/auth/api-keys/introspect, с токеном сервиса) с кешем на
+// APIKeyCacheTTL; ключи в кеше — по SHA-256
+type RemoteAPIKeys struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	cache   map[[32]byte]cachedKey
+	sweepAt time.Time // следующая чистка истёкших записей
+}
+
+type cachedKey struct {
+	info APIKeyInfo
+	exp  time.Time
+}
+
+// negativeTTL — неверный ключ тоже кешируем, но ненадолго
+const negativeTTL = 10 * time.Second
+
+// maxCachedKeys — сверх этого неверные ключи не кешируются (поток случайных
+// ключей не раздувает кеш); действующие кешируются всегда
+const maxCachedKeys = 10000
+
+// NewRemoteAPIKeys — client должен подставлять токен сервиса (svcauth)
+func NewRemoteAPIKeys(url string, client *http.Client) *RemoteAPIKeys {
+	return &RemoteAPIKeys{url: url, client: client, cache: map[[32]byte]cachedKey{}}
+}
+
+func (s *RemoteAPIKeys) LookupAPIKey(ctx context.Context, key string) (APIKeyInfo, error) {
+	h := sha256.Sum256([]byte(key))
+	now := time.Now()
+	s.mu.Lock()
+	ck, ok := s.cache[h]
+	s.mu.Unlock()
+	if ok && now.Before(ck.exp) {
+		return ck.info, nil
+	}
+
+	body, _ := json.Marshal(map[string]string{"key": key})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return APIKeyInfo{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return APIKeyInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return APIKeyInfo{}, fmt.Errorf("api key introspect: %s", resp.Status)
+	}
+	var info APIKeyInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return APIKeyInfo{}, err
+	}
+	ttl := APIKeyCacheTTL
+	if !info.Active {
+		ttl = negativeTTL
+	}
+	s.mu.Lock()
+	// истёкшие записи чистятся не чаще раза в negativeTTL, а не на каждый промах
+	if now.After(s.sweepAt) {
+		for k, v := range s.cache {
+			if now.After(v.exp) {
+				delete(s.cache, k)
+			}
+		}
+		s.sweepAt = now.Add(negativeTTL)
+	}
+	if info.Active || len(s.cache) < maxCachedKeys {
+		s.cache[h] = cachedKey{info: info, exp: now.Add(ttl)}
+	}
+	s.mu.Unlock()
+	return info, nil
+}
+
+// keyLimiter — token bucket на ключ: RateLimit запросов в минуту.
+// Лимит считается в каждом экземпляре сервиса отдельно.
+type keyLimiter struct {
+	mu      sync.Mutex
+	buckets map[int64]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newKeyLimiter() *keyLimiter { return &keyLimiter{buckets: map[int64]*bucket{}} }
+
+// allow — можно ли выполнить запрос; иначе — через сколько появится токен
+func (l *keyLimiter) allow(id int64, perMinute int, now time.Time) (bool, time.Duration) {
+	if perMinute <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rate := float64(perMinute) / 60 // в секунду
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: float64(perMinute), last: now}
+		l.buckets[id] = b
+	}
+	b.tokens = min(float64(perMinute), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// WithAPIKeys — принимать, кроме bearer-токенов, ключи интеграций в X-API-Key
+func (m *MW) WithAPIKeys(src APIKeySource) *MW {
+	m.apiKeys = src
+	m.keyLimits = newKeyLimiter()
+	return m
+}
+
+// authenticateAPIKey — проверка ключа и его лимита; при ошибке ответ уже записан
+func (m *MW) authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string) (*Claims, bool) {
+	info, err := m.apiKeys.LookupAPIKey(r.Context(), key)
+	if err != nil {
+		http.Error(w, "auth unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if !info.Active {
+		http.Error(w, ErrInvalidAPIKey.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	c := info.Claims()
+	if m.revoked != nil && m.revoked.Revoked(c) {
+		http.Error(w, ErrInvalidAPIKey.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	if ok, wait := m.keyLimits.allow(info.ID, info.RateLimit, time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return c, true
+}
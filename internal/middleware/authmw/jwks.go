@@ -0,0 +1,173 @@
+package authmw
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySource — откуда MW берёт открытые ключи проверки подписи по kid
+type KeySource interface {
+	PublicKey(kid string) (crypto.PublicKey, error)
+}
+
+// JWK — открытый ключ в формате RFC 7517 (RSA или Ed25519)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"` // OKP
+	X   string `json:"x,omitempty"`   // OKP
+	N   string `json:"n,omitempty"`   // RSA
+	E   string `json:"e,omitempty"`   // RSA
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK — JWK для открытого ключа RSA или Ed25519
+func NewJWK(kid string, pub crypto.PublicKey) (JWK, error) {
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", Kid: kid, Use: "sig", Alg: "EdDSA",
+			X: base64.RawURLEncoding.EncodeToString(k)}, nil
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported key type %T", pub)
+}
+
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// RemoteJWKS — JWKS сервиса auth, загружаемый по HTTP и кешируемый.
+// Неизвестный kid вызывает внеочередную загрузку (не чаще minRefetch) —
+// так подхватываются новые ключи при ротации. Загрузка идёт без блокировки и
+// одна на всех: пока она идёт, запросы с известным kid проверяются по старому
+// набору, остальные ждут её результата.
+type RemoteJWKS struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	fetching  chan struct{} // закрывается по окончании текущей загрузки; nil — загрузки нет
+	fetchErr  error         // ошибка последней загрузки
+}
+
+const minRefetch = 30 * time.Second
+
+func NewRemoteJWKS(url string, ttl time.Duration) *RemoteJWKS {
+	return &RemoteJWKS{url: url, ttl: ttl, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (j *RemoteJWKS) PublicKey(kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	k, ok := j.keys[kid]
+	age := time.Since(j.fetchedAt)
+	if (ok && age < j.ttl) || (!ok && age < minRefetch && j.fetching == nil) {
+		j.mu.Unlock()
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return k, nil
+	}
+	done, leader := j.fetching, false
+	if done == nil {
+		done, leader = make(chan struct{}), true
+		j.fetching, j.fetchedAt = done, time.Now()
+	}
+	j.mu.Unlock()
+
+	switch {
+	case leader:
+		keys, err := j.fetch()
+		j.mu.Lock()
+		if err == nil {
+			j.keys = keys
+		}
+		j.fetchErr, j.fetching = err, nil
+		j.mu.Unlock()
+		close(done)
+	case ok:
+		return k, nil // набор обновляет другой запрос, ключ пока прежний
+	default:
+		<-done
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.fetchErr != nil {
+		if ok {
+			return k, nil // auth недоступен — живём на старом наборе
+		}
+		return nil, j.fetchErr
+	}
+	if k, ok = j.keys[kid]; !ok {
+		return nil, ErrUnknownKey
+	}
+	return k, nil
+}
+
+// fetch — загрузка набора ключей; вызывается без j.mu
+func (j *RemoteJWKS) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: %s", resp.Status)
+	}
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
@@ -1,7 +1,11 @@
 package authmw
 
 import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -9,31 +13,104 @@ import (
 )
 
 type MW struct {
-	secret []byte
+	keys    KeySource
+	revoked Revocations
+
+	apiKeys   APIKeySource // nil — ключи интеграций не принимаются
+	keyLimits *keyLimiter
 }
 
-func New(secret string) *MW { return &MW{secret: []byte(secret)} }
+// New — проверка токенов по ключам keys; revoked — список отзыва (nil — не проверять)
+func New(keys KeySource, revoked Revocations) *MW { return &MW{keys: keys, revoked: revoked} }
 
 type Claims struct {
-	Sub  string `json:"sub"`
-	Role string `json:"role"`
+	Sub       string `json:"sub"`
+	UserID    int64  `json:"uid"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid,omitempty"`
+	// Permissions — разрешения роли на момент выдачи токена
+	Permissions []string `json:"perms,omitempty"`
+	// LogisticsPointID — логточка, к которой привязан менеджер
+	LogisticsPointID *int64 `json:"lpid,omitempty"`
+	// OrganizationID, APIKeyID — для ключей интеграций (в JWT не выдаются)
+	OrganizationID *int64 `json:"org,omitempty"`
+	APIKeyID       int64  `json:"akid,omitempty"`
+	// Scope — scope, выданные OIDC-приложению (только в токенах с aud приложения)
+	Scope string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func (m *MW) parse(tokenStr string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (any, error) {
-		return m.secret, nil
-	})
+// Issuer — значение iss в токенах сервиса auth
+const Issuer = "auth"
+
+// RoleService — роль в токенах сервисов (client credentials); sub — id сервиса
+const RoleService = "service"
+
+// Audience — aud токенов для API сервисов. У access-токенов OIDC-приложений
+// aud — id приложения, API их не принимает
+const Audience = "api"
+
+// Parse — разбор и проверка подписи токена для API (aud = Audience)
+func (m *MW) Parse(tokenStr string) (*Claims, error) {
+	return m.parse(tokenStr, jwt.WithAudience(Audience))
+}
+
+// ParseAnyAudience — Parse без проверки aud: для /userinfo и отзыва токенов,
+// куда приходят и токены OIDC-приложений
+func (m *MW) ParseAnyAudience(tokenStr string) (*Claims, error) {
+	return m.parse(tokenStr)
+}
+
+func (m *MW) parse(tokenStr string, opts ...jwt.ParserOption) (*Claims, error) {
+	opts = append([]jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(Issuer),
+		jwt.WithExpirationRequired(),
+	}, opts...)
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, m.keyFunc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if m.revoked != nil && m.revoked.Revoked(c) {
+		return nil, ErrTokenRevoked
+	}
+	return c, nil
+}
+
+// ForAPI — токен выдан для API, а не OIDC-приложению
+func (c *Claims) ForAPI() bool { return slices.Contains(c.Audience, Audience) }
+
+// keyFunc — ключ по kid из заголовка; тип ключа должен соответствовать alg
+func (m *MW) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownKey
+	}
+	pub, err := m.keys.PublicKey(kid)
 	if err != nil {
 		return nil, err
 	}
-	if c, ok := token.Claims.(*Claims); ok && token.Valid {
-		return c, nil
+	switch pub.(type) {
+	case ed25519.PublicKey:
+		if t.Method != jwt.SigningMethodEdDSA {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+	case *rsa.PublicKey:
+		if t.Method != jwt.SigningMethodRS256 {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+	default:
+		return nil, ErrUnknownKey
 	}
-	return nil, jwt.ErrTokenInvalidClaims
+	return pub, nil
 }
 
-func bearer(r *http.Request) string {
+// Bearer — токен из заголовка Authorization, пусто если его нет
+func Bearer(r *http.Request) string {
 	h := r.Header.Get("Authorization")
 	if !strings.HasPrefix(strings.ToLower(h), "bearer ") {
 		return ""
@@ -41,19 +118,42 @@ func bearer(r *http.Request) string {
 	return strings.TrimSpace(h[len("Bearer "):])
 }
 
+// authenticate — разбор bearer-токена (или ключа интеграции, если включены);
+// при ошибке ответ уже записан
+func (m *MW) authenticate(w http.ResponseWriter, r *http.Request) (*Claims, bool) {
+	tok := Bearer(r)
+	if key := r.Header.Get(APIKeyHeader); tok == "" && key != "" && m.apiKeys != nil {
+		return m.authenticateAPIKey(w, r, key)
+	}
+	if tok == "" {
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return nil, false
+	}
+	c, err := m.Parse(tok)
+	if errors.Is(err, ErrTokenRevoked) {
+		http.Error(w, "token revoked", http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+	return c, true
+}
+
+// serve — передаёт запрос дальше с Principal в контексте
+func serve(next http.Handler, w http.ResponseWriter, r *http.Request, c *Claims) {
+	next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), c.Principal())))
+}
+
 // RequireToken — просто валидный токен
 func (m *MW) RequireToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tok := bearer(r)
-		if tok == "" {
-			http.Error(w, "missing bearer token", http.StatusUnauthorized)
-			return
-		}
-		if _, err := m.parse(tok); err != nil {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+		c, ok := m.authenticate(w, r)
+		if !ok {
 			return
 		}
-		next.ServeHTTP(w, r)
+		serve(next, w, r, c)
 	})
 }
 
@@ -65,23 +165,63 @@ func (m *MW) RequireRoles(roles ...string) mux.MiddlewareFunc {
 	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tok := bearer(r)
-			if tok == "" {
-				http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			c, ok := m.authenticate(w, r)
+			if !ok {
+				return
+			}
+			if len(allowed) > 0 {
+				if _, ok := allowed[c.Role]; !ok {
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+			}
+			serve(next, w, r, c)
+		})
+	}
+}
+
+// RequireService — только токены сервисов с указанными id (пусто — любой сервис)
+func (m *MW) RequireService(clients ...string) mux.MiddlewareFunc {
+	allowed := map[string]struct{}{}
+	for _, c := range clients {
+		allowed[c] = struct{}{}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, ok := m.authenticate(w, r)
+			if !ok {
 				return
 			}
-			c, err := m.parse(tok)
-			if err != nil {
-				http.Error(w, "invalid token", http.StatusUnauthorized)
+			if c.Role != RoleService {
+				http.Error(w, "forbidden", http.StatusForbidden)
 				return
 			}
 			if len(allowed) > 0 {
-				if _, ok := allowed[c.Role]; !ok {
+				if _, ok := allowed[c.Sub]; !ok {
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+			}
+			serve(next, w, r, c)
+		})
+	}
+}
+
+// RequirePermission — токен должен содержать все перечисленные разрешения
+func (m *MW) RequirePermission(perms ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, ok := m.authenticate(w, r)
+			if !ok {
+				return
+			}
+			for _, p := range perms {
+				if !c.Has(p) {
 					http.Error(w, "forbidden", http.StatusForbidden)
 					return
 				}
 			}
-			next.ServeHTTP(w, r)
+			serve(next, w, r, c)
 		})
 	}
 }
@@ -0,0 +1,48 @@
+package authmw
+
+// Каталог разрешений. Роли сопоставляются наборам разрешений в хранилище auth,
+// разрешения попадают в токен (claim perms) и проверяются RequirePermission.
+const (
+	PermApplicationsRead         = "applications:read"
+	PermApplicationsCreate       = "applications:create"
+	PermApplicationsUpdateStatus = "applications:update_status"
+	PermApplicationsUpdate       = "applications:update" // правка полей заявки
+
+	PermLogisticApplicationsRead         = "logistic_applications:read"
+	PermLogisticApplicationsUpdateStatus = "logistic_applications:update_status"
+
+	PermRoutesCreate = "routes:create"
+	PermRoutesAssign = "routes:assign"
+	PermRoutesSend   = "routes:send"
+
+	PermAuditRead  = "audit:read"
+	PermAuditWrite = "audit:write"
+
+	PermUsersManage = "users:manage"
+)
+
+// Роли пользователей; набор разрешений роли хранит auth
+const (
+	RoleAdmin            = "admin"
+	RoleOfficeManager    = "office_manager"
+	RoleLogisticsManager = "logistics_manager" // привязан к логточке (claim lpid)
+)
+
+// Permissions — весь каталог, в порядке объявления
+var Permissions = []string{
+	PermApplicationsRead, PermApplicationsCreate, PermApplicationsUpdateStatus, PermApplicationsUpdate,
+	PermLogisticApplicationsRead, PermLogisticApplicationsUpdateStatus,
+	PermRoutesCreate, PermRoutesAssign, PermRoutesSend,
+	PermAuditRead, PermAuditWrite,
+	PermUsersManage,
+}
+
+// Has — есть ли у владельца токена разрешение
+func (c *Claims) Has(perm string) bool {
+	for _, p := range c.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package authmw
+
+import "context"
+
+// Principal — кто выполняет запрос; кладётся в контекст middleware после проверки токена
+type Principal struct {
+	UserID           int64  // 0 для сервисов
+	Username         string // для сервисов — id клиента
+	Role             string
+	LogisticsPointID *int64
+	Permissions      []string
+	// SessionID — сессия входа (sid), пусто для сервисов и ключей интеграций
+	SessionID string
+	// OrganizationID — организация ключа интеграции; nil для людей и сервисов
+	OrganizationID *int64
+	APIKeyID       int64
+}
+
+func (c *Claims) Principal() Principal {
+	return Principal{
+		UserID:           c.UserID,
+		Username:         c.Sub,
+		Role:             c.Role,
+		LogisticsPointID: c.LogisticsPointID,
+		Permissions:      c.Permissions,
+		SessionID:        c.SessionID,
+		OrganizationID:   c.OrganizationID,
+		APIKeyID:         c.APIKeyID,
+	}
+}
+
+// IsService — вызов от другого сервиса, а не от человека
+func (p Principal) IsService() bool { return p.Role == RoleService }
+
+// IsAPIKey — вызов по ключу интеграции
+func (p Principal) IsAPIKey() bool { return p.Role == RoleAPIKey }
+
+// ManagerID — id менеджера для отметок «кто создал/изменил»; nil для сервисов
+func (p Principal) ManagerID() *int64 {
+	if p.IsService() || p.IsAPIKey() || p.UserID == 0 {
+		return nil
+	}
+	id := p.UserID
+	return &id
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,156 @@
+package authmw
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+// Revocations — проверка токена по списку отзыва
+type Revocations interface {
+	Revoked(c *Claims) bool
+}
+
+// RevocationList — отозванные токены (jti) и сессии (sid), которые ещё не
+// истекли сами; публикуется auth на /internal/auth/revocations
+type RevocationList struct {
+	JTIs     []string `json:"jti"`
+	Sessions []string `json:"sid"`
+}
+
+// ETag — версия списка для условных запросов
+func (l RevocationList) ETag() string {
+	b, _ := json.Marshal(l)
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+type revocationSet struct {
+	jti map[string]struct{}
+	sid map[string]struct{}
+}
+
+func newRevocationSet(l RevocationList) revocationSet {
+	s := revocationSet{jti: make(map[string]struct{}, len(l.JTIs)), sid: make(map[string]struct{}, len(l.Sessions))}
+	for _, v := range l.JTIs {
+		s.jti[v] = struct{}{}
+	}
+	for _, v := range l.Sessions {
+		s.sid[v] = struct{}{}
+	}
+	return s
+}
+
+func (s revocationSet) revoked(c *Claims) bool {
+	if _, ok := s.jti[c.ID]; ok && c.ID != "" {
+		return true
+	}
+	_, ok := s.sid[c.SessionID]
+	return ok && c.SessionID != ""
+}
+
+// RevocationCache — список отзыва в памяти, обновляется в фоне раз в interval:
+// проверка токена не ходит по сети. Пока источник недоступен, действует
+// последний загруженный список.
+type RevocationCache struct {
+	fetch    func(ctx context.Context, etag string) (RevocationList, string, error)
+	interval time.Duration
+
+	mu   sync.RWMutex
+	set  revocationSet
+	etag string
+}
+
+// errNotModified — у источника та же версия списка
+var errNotModified = errors.New("not modified")
+
+// NewRevocationCache — кеш поверх произвольного источника (auth читает свою БД)
+func NewRevocationCache(fetch func(ctx context.Context) (RevocationList, error), interval time.Duration) *RevocationCache {
+	return newRevocationCache(func(ctx context.Context, _ string) (RevocationList, string, error) {
+		l, err := fetch(ctx)
+		return l, "", err
+	}, interval)
+}
+
+// NewRemoteRevocations — список отзыва auth по HTTP; client подставляет токен
+// сервиса (список отдаётся только сервисам)
+func NewRemoteRevocations(url string, client *http.Client, interval time.Duration) *RevocationCache {
+	return newRevocationCache(func(ctx context.Context, etag string) (RevocationList, string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return RevocationList{}, "", err
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return RevocationList{}, "", err
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusNotModified:
+			return RevocationList{}, etag, errNotModified
+		case http.StatusOK:
+		default:
+			return RevocationList{}, "", fmt.Errorf("revocations: %s", resp.Status)
+		}
+		var l RevocationList
+		if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+			return RevocationList{}, "", err
+		}
+		return l, resp.Header.Get("ETag"), nil
+	}, interval)
+}
+
+func newRevocationCache(fetch func(context.Context, string) (RevocationList, string, error), interval time.Duration) *RevocationCache {
+	c := &RevocationCache{fetch: fetch, interval: interval, set: newRevocationSet(RevocationList{})}
+	go c.loop()
+	return c
+}
+
+func (c *RevocationCache) loop() {
+	for {
+		if err := c.Refresh(context.Background()); err != nil {
+			log.Printf("[authmw] revocations refresh: %v", err)
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+// Refresh — загрузить список сейчас, не дожидаясь очередного обновления
+func (c *RevocationCache) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	c.mu.RLock()
+	etag := c.etag
+	c.mu.RUnlock()
+
+	l, etag, err := c.fetch(ctx, etag)
+	if errors.Is(err, errNotModified) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	set := newRevocationSet(l)
+	c.mu.Lock()
+	c.set, c.etag = set, etag
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *RevocationCache) Revoked(cl *Claims) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.set.revoked(cl)
+}
@@ -0,0 +1,267 @@
+// Package migrate — версионные миграции схемы PostgreSQL.
+//
+// Миграции — пары файлов NNNN_имя.up.sql / NNNN_имя.down.sql, встроенные в
+// сервис (embed). Применённые версии хранятся в schema_migrations; каждая
+// миграция выполняется в своей транзакции. Одновременный запуск нескольких
+// реплик безопасен: всё выполняется под advisory-блокировкой БД.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// lockKey — ключ pg_advisory_lock; у каждого сервиса своя БД, поэтому общий
+const lockKey int64 = 0x6137_6d69_6772 // "a7migr"
+
+var fileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+var ErrNoDown = errors.New("migration has no down script")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string // пусто — миграция необратима
+}
+
+func (m Migration) String() string { return fmt.Sprintf("%04d_%s", m.Version, m.Name) }
+
+// State — миграция и время её применения (nil — не применена).
+// Unknown — версия есть в БД, но не в сборке (откат бинаря)
+type State struct {
+	Migration
+	AppliedAt *time.Time
+	Unknown   bool
+}
+
+type Migrator struct {
+	db         *sql.DB
+	service    string
+	migrations []Migration // по возрастанию версии
+}
+
+// New читает миграции из корня fsys; service — только для логов
+func New(db *sql.DB, service string, fsys fs.FS) (*Migrator, error) {
+	ms, err := Load(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("%s migrations: %w", service, err)
+	}
+	return &Migrator{db: db, service: service, migrations: ms}, nil
+}
+
+// MustUp — применить миграции при старте сервиса; ошибка фатальна
+func MustUp(db *sql.DB, service string, fsys fs.FS) {
+	m, err := New(db, service, fsys)
+	if err != nil {
+		log.Fatalf("%s: %v", service, err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		log.Fatalf("%s migrate: %v", service, err)
+	}
+}
+
+// Load — миграции из корня fsys, отсортированные по версии
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m := fileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected file %q (want NNNN_name.up.sql or .down.sql)", e.Name())
+		}
+		v, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("bad version in %q", e.Name())
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mg := byVersion[v]
+		if mg == nil {
+			mg = &Migration{Version: v, Name: m[2]}
+			byVersion[v] = mg
+		}
+		if mg.Name != m[2] {
+			return nil, fmt.Errorf("version %d used by %q and %q", v, mg.Name, m[2])
+		}
+		if m[3] == "up" {
+			mg.Up = string(body)
+		} else {
+			mg.Down = string(body)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("%s: missing up script", m)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Up применяет все ещё не применённые миграции; возвращает применённые сейчас
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mg, mg.Up,
+				`INSERT INTO schema_migrations(version, name) VALUES ($1, $2)`, mg.Version, mg.Name); err != nil {
+				return err
+			}
+			log.Printf("[migrate] %s: applied %s", m.service, mg)
+			done = append(done, mg)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down откатывает steps последних применённых миграций
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		for _, v := range versions[:min(steps, len(versions))] {
+			mg, ok := m.find(v)
+			if !ok {
+				return fmt.Errorf("version %d is applied but unknown to this build", v)
+			}
+			if mg.Down == "" {
+				return fmt.Errorf("%s: %w", mg, ErrNoDown)
+			}
+			if err := m.apply(ctx, conn, mg, mg.Down,
+				`DELETE FROM schema_migrations WHERE version=$1`, mg.Version); err != nil {
+				return err
+			}
+			log.Printf("[migrate] %s: rolled back %s", m.service, mg)
+			done = append(done, mg)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status — все миграции сборки и применённые версии, которых в ней нет
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	var out []State
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			st := State{Migration: mg}
+			if at, ok := applied[mg.Version]; ok {
+				st.AppliedAt = &at
+				delete(applied, mg.Version)
+			}
+			out = append(out, st)
+		}
+		for v, at := range applied {
+			out = append(out, State{Migration: Migration{Version: v}, AppliedAt: &at, Unknown: true})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+		return nil
+	})
+	return out, err
+}
+
+func (m *Migrator) find(v int64) (Migration, bool) {
+	for _, mg := range m.migrations {
+		if mg.Version == v {
+			return mg, true
+		}
+	}
+	return Migration{}, false
+}
+
+// apply — скрипт и запись в schema_migrations одной транзакцией
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mg Migration, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("%s: %w", mg, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// locked — fn на отдельном соединении под pg_advisory_lock (блокировка
+// сессионная, поэтому все запросы идут через одно соединение)
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return err
+	}
+	// отпускаем и при отменённом ctx, иначе блокировка живёт до закрытия соединения в пуле
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+	if _, err := conn.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version BIGINT PRIMARY KEY,
+  name TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int64]time.Time{}
+	for rows.Next() {
+		var v int64
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		out[v] = at
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,228 @@
+package office
+
+import (
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"template/internal/appstatus"
+)
+
+var (
+	// ErrVersionMismatch — заявку успели изменить (If-Match не совпал)
+	ErrVersionMismatch = errors.New("application was modified")
+	ErrEmptyPatch      = errors.New("nothing to update")
+)
+
+const (
+	maxTextLen = 500
+	// NUMERIC(10,2)
+	maxAmount = 99_999_999.99
+)
+
+// ApplicationPatch — изменяемые поля заявки; nil — не менять.
+// SenderEmail и SpecialRequirements: "" — очистить
+type ApplicationPatch struct {
+	LogisticsPointID *int64 `json:"logistics_point_id"`
+
+	SenderOrgName      *string `json:"sender_org_name"`
+	SenderINN          *string `json:"sender_inn"`
+	SenderContactFIO   *string `json:"sender_contact_fio"`
+	SenderContactPhone *string `json:"sender_contact_phone"`
+	SenderEmail        *string `json:"sender_email"`
+
+	CargoName           *string  `json:"cargo_name"`
+	CargoCount          *int     `json:"cargo_count"`
+	CargoWeight         *float64 `json:"cargo_weight"`
+	CargoVolume         *float64 `json:"cargo_volume"`
+	SpecialRequirements *string  `json:"special_requirements"`
+
+	RecipientOrgName      *string `json:"recipient_org_name"`
+	RecipientAddress      *string `json:"recipient_address"`
+	RecipientContactFIO   *string `json:"recipient_contact_fio"`
+	RecipientContactPhone *string `json:"recipient_contact_phone"`
+}
+
+// ValidationError — ошибки по полям (имя поля в JSON → текст)
+type ValidationError struct {
+	Fields map[string]string `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for f := range e.Fields {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	return "invalid fields: " + strings.Join(names, ", ")
+}
+
+// FrozenError — поля, которые в текущем статусе менять нельзя
+type FrozenError struct {
+	Status ApplicationStatus `json:"status"`
+	Fields []string          `json:"fields"`
+}
+
+func (e *FrozenError) Error() string {
+	return fmt.Sprintf("fields %s cannot be changed in status %s", strings.Join(e.Fields, ", "), e.Status)
+}
+
+// editableIn — в каких статусах можно менять группу полей: точка приёма — пока
+// заявка новая, груз — до отгрузки, отправитель и получатель — до доставки.
+// DELIVERED и CANCELLED не редактируются
+var editableIn = map[string][]ApplicationStatus{
+	"logistics_point": {appstatus.New},
+	"cargo":           {appstatus.New, appstatus.InProgress},
+	"sender":          {appstatus.New, appstatus.InProgress, appstatus.Shipped},
+	"recipient":       {appstatus.New, appstatus.InProgress, appstatus.Shipped},
+}
+
+// patchField — поле патча: имя в JSON, группа и задано ли оно
+type patchField struct {
+	name  string
+	group string
+	set   bool
+}
+
+func (p ApplicationPatch) fields() []patchField {
+	return []patchField{
+		{"logistics_point_id", "logistics_point", p.LogisticsPointID != nil},
+		{"sender_org_name", "sender", p.SenderOrgName != nil},
+		{"sender_inn", "sender", p.SenderINN != nil},
+		{"sender_contact_fio", "sender", p.SenderContactFIO != nil},
+		{"sender_contact_phone", "sender", p.SenderContactPhone != nil},
+		{"sender_email", "sender", p.SenderEmail != nil},
+		{"cargo_name", "cargo", p.CargoName != nil},
+		{"cargo_count", "cargo", p.CargoCount != nil},
+		{"cargo_weight", "cargo", p.CargoWeight != nil},
+		{"cargo_volume", "cargo", p.CargoVolume != nil},
+		{"special_requirements", "cargo", p.SpecialRequirements != nil},
+		{"recipient_org_name", "recipient", p.RecipientOrgName != nil},
+		{"recipient_address", "recipient", p.RecipientAddress != nil},
+		{"recipient_contact_fio", "recipient", p.RecipientContactFIO != nil},
+		{"recipient_contact_phone", "recipient", p.RecipientContactPhone != nil},
+	}
+}
+
+// checkEditable — ErrEmptyPatch или *FrozenError
+func (p ApplicationPatch) checkEditable(status ApplicationStatus) error {
+	var frozen []string
+	n := 0
+	for _, f := range p.fields() {
+		if !f.set {
+			continue
+		}
+		n++
+		if !slices.Contains(editableIn[f.group], status) {
+			frozen = append(frozen, f.name)
+		}
+	}
+	if n == 0 {
+		return ErrEmptyPatch
+	}
+	if len(frozen) > 0 {
+		return &FrozenError{Status: status, Fields: frozen}
+	}
+	return nil
+}
+
+// normalize — обрезает пробелы и проверяет значения; *ValidationError при ошибках
+func (p *ApplicationPatch) normalize() error {
+	errs := map[string]string{}
+	required := func(name string, v *string) {
+		if v == nil {
+			return
+		}
+		*v = strings.TrimSpace(*v)
+		switch {
+		case *v == "":
+			errs[name] = "required"
+		case utf8.RuneCountInString(*v) > maxTextLen:
+			errs[name] = fmt.Sprintf("longer than %d characters", maxTextLen)
+		}
+	}
+	optional := func(name string, v *string) {
+		if v == nil {
+			return
+		}
+		*v = strings.TrimSpace(*v)
+		if utf8.RuneCountInString(*v) > maxTextLen {
+			errs[name] = fmt.Sprintf("longer than %d characters", maxTextLen)
+		}
+	}
+	amount := func(name string, v *float64) {
+		if v != nil && (*v <= 0 || *v > maxAmount) {
+			errs[name] = "must be positive and at most 99999999.99"
+		}
+	}
+
+	if p.LogisticsPointID != nil && *p.LogisticsPointID <= 0 {
+		errs["logistics_point_id"] = "must be positive"
+	}
+	required("sender_org_name", p.SenderOrgName)
+	required("sender_inn", p.SenderINN)
+	if p.SenderINN != nil && errs["sender_inn"] == "" && !validINN(*p.SenderINN) {
+		errs["sender_inn"] = "must be 10 or 12 digits"
+	}
+	required("sender_contact_fio", p.SenderContactFIO)
+	required("sender_contact_phone", p.SenderContactPhone)
+	if p.SenderContactPhone != nil && errs["sender_contact_phone"] == "" && !validPhone(*p.SenderContactPhone) {
+		errs["sender_contact_phone"] = "invalid phone"
+	}
+	optional("sender_email", p.SenderEmail)
+	if p.SenderEmail != nil && *p.SenderEmail != "" && errs["sender_email"] == "" {
+		if a, err := netmail.ParseAddress(*p.SenderEmail); err != nil || a.Address != *p.SenderEmail {
+			errs["sender_email"] = "invalid email"
+		}
+	}
+	required("cargo_name", p.CargoName)
+	if p.CargoCount != nil && *p.CargoCount <= 0 {
+		errs["cargo_count"] = "must be positive"
+	}
+	amount("cargo_weight", p.CargoWeight)
+	amount("cargo_volume", p.CargoVolume)
+	optional("special_requirements", p.SpecialRequirements)
+	required("recipient_org_name", p.RecipientOrgName)
+	required("recipient_address", p.RecipientAddress)
+	required("recipient_contact_fio", p.RecipientContactFIO)
+	required("recipient_contact_phone", p.RecipientContactPhone)
+	if p.RecipientContactPhone != nil && errs["recipient_contact_phone"] == "" && !validPhone(*p.RecipientContactPhone) {
+		errs["recipient_contact_phone"] = "invalid phone"
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// validINN — 10 цифр (организация) или 12 (ИП)
+func validINN(s string) bool {
+	if len(s) != 10 && len(s) != 12 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validPhone — от 10 до 15 цифр, допускаются +, пробелы, скобки и дефисы
+func validPhone(s string) bool {
+	digits := 0
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '+' && i == 0, r == ' ', r == '(', r == ')', r == '-':
+		default:
+			return false
+		}
+	}
+	return digits >= 10 && digits <= 15
+}
@@ -2,36 +2,65 @@ package office
 
 import (
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+
+	"template/internal/appstatus"
+	"template/internal/middleware/authmw"
 )
 
-type Handler struct{ svc Service }
+type Handler struct {
+	svc Service
+	mw  *authmw.MW
+}
 
-func NewHandler(svc Service) *Handler { return &Handler{svc: svc} }
+func NewHandler(svc Service, mw *authmw.MW) *Handler { return &Handler{svc: svc, mw: mw} }
 
 func (h *Handler) Register(r *mux.Router) {
 	// здесь r — уже суброутер с префиксом /office
-	r.HandleFunc("/applications", h.create).Methods("POST")
-	r.HandleFunc("/applications/{id:[0-9]+}", h.getByID).Methods("GET")
-	r.HandleFunc("/applications/{id:[0-9]+}/status", h.updateStatus).Methods("POST")
-	r.HandleFunc("/applications", h.list).Methods("GET")
+	can := func(perm string, f http.HandlerFunc) http.Handler { return h.mw.RequirePermission(perm)(f) }
+
+	r.Handle("/applications", can(authmw.PermApplicationsCreate, h.create)).Methods("POST")
+	r.Handle("/applications/{id:[0-9]+}", can(authmw.PermApplicationsRead, h.getByID)).Methods("GET")
+	r.Handle("/applications/{id:[0-9]+}", can(authmw.PermApplicationsUpdate, h.update)).Methods("PATCH")
+	r.Handle("/applications/{id:[0-9]+}/status", can(authmw.PermApplicationsUpdateStatus, h.updateStatus)).Methods("POST")
+	r.Handle("/applications/{id:[0-9]+}/history", can(authmw.PermApplicationsRead, h.history)).Methods("GET")
+	r.Handle("/applications", can(authmw.PermApplicationsRead, h.list)).Methods("GET")
+	r.Handle("/applications/search", can(authmw.PermApplicationsRead, h.search)).Methods("GET")
 	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
 }
 
+// RegisterInternal — маршруты для других сервисов; r — суброутер с префиксом /internal/office
+func (h *Handler) RegisterInternal(r *mux.Router) {
+	r.HandleFunc("/applications/{id:[0-9]+}/status", h.updateStatus).Methods("POST")
+}
+
 func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
 	var req CreateApplicationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	app, err := h.svc.Create(r.Context(), req)
+	app, replayed, err := h.svc.CreateIdempotent(r.Context(), req, r.Header.Get("Idempotency-Key"))
+	if errors.Is(err, ErrIdempotencyKeyReused) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	w.Header().Set("ETag", etag(app))
 	respondJSON(w, http.StatusCreated, app)
 }
 
@@ -42,9 +71,55 @@ func (h *Handler) getByID(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	w.Header().Set("ETag", etag(app))
 	respondJSON(w, http.StatusOK, app)
 }
 
+// etag — по версии заявки
+func etag(app Application) string { return `"` + strconv.FormatInt(app.Version, 10) + `"` }
+
+// update — PATCH с обязательным If-Match: без него 428, с устаревшим ETag 412
+func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if match == "" {
+		http.Error(w, "If-Match required", http.StatusPreconditionRequired)
+		return
+	}
+	version, err := strconv.ParseInt(strings.Trim(match, `"`), 10, 64)
+	if err != nil || !strings.HasPrefix(match, `"`) {
+		http.Error(w, "application was modified", http.StatusPreconditionFailed)
+		return
+	}
+	var p ApplicationPatch
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields() // статус и служебные поля так не меняются
+	if err := dec.Decode(&p); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	app, err := h.svc.Update(r.Context(), id, version, p)
+	var ve *ValidationError
+	var fe *FrozenError
+	switch {
+	case err == nil:
+		w.Header().Set("ETag", etag(app))
+		respondJSON(w, http.StatusOK, app)
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, ErrVersionMismatch):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.As(err, &ve):
+		respondJSON(w, http.StatusBadRequest, map[string]any{"error": "validation failed", "fields": ve.Fields})
+	case errors.As(err, &fe):
+		respondJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "status": fe.Status, "fields": fe.Fields})
+	case errors.Is(err, ErrEmptyPatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "server error", http.StatusInternalServerError)
+	}
+}
+
 func (h *Handler) updateStatus(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	var req UpdateStatusRequest
@@ -52,19 +127,20 @@ func (h *Handler) updateStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad json", http.StatusBadRequest)
 		return
 	}
-	if err := h.svc.UpdateStatus(r.Context(), id, req.Status); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := h.svc.UpdateStatus(r.Context(), id, req); err != nil {
+		writeStatusError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
-	var st *string
-	if v := r.URL.Query().Get("status"); v != "" {
-		st = &v
+func (h *Handler) history(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	list, err := h.svc.History(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
 	}
-	list, err := h.svc.List(r.Context(), st)
 	if err != nil {
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
@@ -72,6 +148,161 @@ func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, list)
 }
 
+// writeStatusError — запрещённый переход и гонка — 409, неизвестный статус — 400
+func writeStatusError(w http.ResponseWriter, r *http.Request, err error) {
+	var te *appstatus.TransitionError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+	case errors.As(err, &te), errors.Is(err, appstatus.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, appstatus.ErrUnknownStatus), errors.Is(err, appstatus.ErrReasonTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	f, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := h.svc.List(r.Context(), f)
+	switch {
+	case errors.Is(err, appstatus.ErrUnknownStatus), errors.Is(err, ErrInvalidFilter), errors.Is(err, ErrInvalidCursor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) search(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			http.Error(w, "bad limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	items, err := h.svc.Search(r.Context(), r.URL.Query().Get("q"), limit)
+	if errors.Is(err, ErrBadSearch) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// parseListFilter — параметры GET /applications; status — через запятую
+// или повтором, даты — RFC 3339 или YYYY-MM-DD (в *_to — весь день включительно)
+func parseListFilter(q url.Values) (ListFilter, error) {
+	f := ListFilter{
+		SenderINN:    strings.TrimSpace(q.Get("sender_inn")),
+		RecipientOrg: strings.TrimSpace(q.Get("recipient_org")),
+		Sort:         q.Get("sort"),
+		Cursor:       q.Get("cursor"),
+	}
+	for _, v := range q["status"] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				f.Statuses = append(f.Statuses, ApplicationStatus(strings.ToUpper(s)))
+			}
+		}
+	}
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		f.Asc = true
+	default:
+		return f, errors.New("bad order")
+	}
+	var err error
+	if f.LogisticsPointID, err = int64Param(q, "logistics_point_id"); err != nil {
+		return f, err
+	}
+	if f.ManagerID, err = int64Param(q, "manager_id"); err != nil {
+		return f, err
+	}
+	for name, dst := range map[string]**time.Time{
+		"created_from": &f.CreatedFrom, "created_to": &f.CreatedTo,
+		"updated_from": &f.UpdatedFrom, "updated_to": &f.UpdatedTo,
+	} {
+		if *dst, err = timeParam(q, name, strings.HasSuffix(name, "_to")); err != nil {
+			return f, err
+		}
+	}
+	for name, dst := range map[string]**float64{
+		"weight_min": &f.WeightMin, "weight_max": &f.WeightMax,
+		"volume_min": &f.VolumeMin, "volume_max": &f.VolumeMax,
+	} {
+		if *dst, err = floatParam(q, name); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			return f, errors.New("bad limit")
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+func int64Param(q url.Values, name string) (*int64, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, errors.New("bad " + name)
+	}
+	return &n, nil
+}
+
+func floatParam(q url.Values, name string) (*float64, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return nil, errors.New("bad " + name)
+	}
+	return &n, nil
+}
+
+// timeParam — RFC 3339 или дата; дата в верхней границе (end) — начало следующего дня
+func timeParam(q url.Values, name string, end bool) (*time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, v); err != nil {
+			return nil, errors.New("bad " + name)
+		}
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	// колонки — TIMESTAMP без зоны в UTC; смещение из RFC 3339 иначе отбросилось бы
+	t = t.UTC()
+	return &t, nil
+}
+
 func respondJSON(w http.ResponseWriter, code int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -0,0 +1,33 @@
+package office
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"template/internal/appstatus"
+)
+
+func TestWriteStatusError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{ErrNotFound, http.StatusNotFound},
+		{appstatus.Check(appstatus.Shipped, appstatus.Cancelled), http.StatusConflict},
+		{fmt.Errorf("wrapped: %w", &appstatus.TransitionError{From: appstatus.New, To: appstatus.Delivered}), http.StatusConflict},
+		{appstatus.ErrConflict, http.StatusConflict},
+		{appstatus.Check(appstatus.New, "LOST"), http.StatusBadRequest},
+		{appstatus.ErrReasonTooLong, http.StatusBadRequest},
+		{errors.New("db down"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writeStatusError(w, httptest.NewRequest("POST", "/office/applications/1/status", nil), tt.err)
+		if w.Code != tt.code {
+			t.Errorf("%v: got %d, want %d", tt.err, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package office
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+// IdempotencyRetention — сколько хранится ключ Idempotency-Key; после этого
+// тот же ключ создаёт новую заявку
+const IdempotencyRetention = 24 * time.Hour
+
+// MaxIdempotencyKeyLen — предельная длина значения заголовка
+const MaxIdempotencyKeyLen = 255
+
+var (
+	ErrBadIdempotencyKey = errors.New("bad Idempotency-Key")
+	// ErrIdempotencyKeyReused — ключ уже использован с другим телом запроса
+	ErrIdempotencyKeyReused = errors.New("Idempotency-Key reused with different request")
+)
+
+// Idempotency — ключ запроса создания: чей он и хэш тела
+type Idempotency struct {
+	Owner       string
+	Key         string
+	RequestHash string
+}
+
+// idempotency — ключ для автора by; пустой key — запрос без ключа (nil)
+func idempotency(key string, by Author, req CreateApplicationRequest) (*Idempotency, error) {
+	if key == "" {
+		return nil, nil
+	}
+	if len(key) > MaxIdempotencyKeyLen {
+		return nil, ErrBadIdempotencyKey
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return nil, ErrBadIdempotencyKey
+		}
+	}
+	owner := ""
+	switch {
+	case by.APIKeyID != nil:
+		owner = "api_key:" + strconv.FormatInt(*by.APIKeyID, 10)
+	case by.ManagerID != nil:
+		owner = "manager:" + strconv.FormatInt(*by.ManagerID, 10)
+	}
+	return &Idempotency{Owner: owner, Key: key, RequestHash: requestHash(req)}, nil
+}
+
+// requestHash — по разобранному запросу, так что порядок полей и пробелы в JSON не важны
+func requestHash(req CreateApplicationRequest) string {
+	b, _ := json.Marshal(req)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// purgeIdempotencyKeys — периодически удаляет ключи старше IdempotencyRetention
+func purgeIdempotencyKeys(repo Repo, every time.Duration) {
+	for range time.Tick(every) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		n, err := repo.PurgeIdempotencyKeys(ctx, time.Now().Add(-IdempotencyRetention))
+		cancel()
+		if err != nil {
+			log.Printf("[office] purge idempotency keys: %v", err)
+		} else if n > 0 {
+			log.Printf("[office] purged %d idempotency keys", n)
+		}
+	}
+}
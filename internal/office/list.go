@@ -0,0 +1,136 @@
+package office
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"template/internal/appstatus"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// ListFilter — фильтры, сортировка и страница списка заявок; пустые поля не фильтруют
+type ListFilter struct {
+	Statuses         []ApplicationStatus
+	LogisticsPointID *int64
+	SenderINN        string
+	RecipientOrg     string // подстрока названия получателя, без учёта регистра
+	ManagerID        *int64 // кто создал заявку
+	CreatedFrom      *time.Time
+	CreatedTo        *time.Time // не включительно
+	UpdatedFrom      *time.Time
+	UpdatedTo        *time.Time
+	WeightMin        *float64
+	WeightMax        *float64
+	VolumeMin        *float64
+	VolumeMax        *float64
+
+	Sort   string // ключ sortKeys; пусто — created_at
+	Asc    bool   // по умолчанию — по убыванию
+	Limit  int
+	Cursor string // next_cursor предыдущей страницы
+}
+
+// ApplicationPage — страница списка; NextCursor пуст на последней
+type ApplicationPage struct {
+	Items      []Application `json:"items"`
+	Total      int64         `json:"total"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// sortKey — колонка сортировки; порядок внутри равных значений — по id.
+// value — значение колонки у заявки для курсора, cast — тип для сравнения с ним
+type sortKey struct {
+	column string
+	cast   string
+	value  func(Application) string
+}
+
+// tsLayout — TIMESTAMP без зоны, с микросекундами, как хранит PostgreSQL
+const tsLayout = "2006-01-02 15:04:05.999999"
+
+var sortKeys = map[string]sortKey{
+	"id":           {"id", "BIGINT", func(a Application) string { return strconv.FormatInt(a.ID, 10) }},
+	"created_at":   {"created_at", "TIMESTAMP", func(a Application) string { return a.CreatedAt.Format(tsLayout) }},
+	"updated_at":   {"updated_at", "TIMESTAMP", func(a Application) string { return a.UpdatedAt.Format(tsLayout) }},
+	"cargo_weight": {"cargo_weight", "NUMERIC", func(a Application) string { return strconv.FormatFloat(a.CargoWeight, 'f', -1, 64) }},
+	"cargo_volume": {"cargo_volume", "NUMERIC", func(a Application) string { return strconv.FormatFloat(a.CargoVolume, 'f', -1, 64) }},
+}
+
+// cursor — позиция после последней заявки страницы; привязан к сортировке,
+// с которой выдан
+type cursor struct {
+	Sort  string `json:"s"`
+	Asc   bool   `json:"a,omitempty"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string, f ListFilter) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.Sort != f.Sort || c.Asc != f.Asc {
+		return cursor{}, ErrInvalidCursor
+	}
+	if !validCursorValue(sortKeys[c.Sort].cast, c.Value) {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// validCursorValue — значение курсора приводится к типу колонки, иначе запрос упал бы в БД
+func validCursorValue(cast, v string) bool {
+	var err error
+	switch cast {
+	case "BIGINT":
+		_, err = strconv.ParseInt(v, 10, 64)
+	case "TIMESTAMP":
+		_, err = time.Parse(tsLayout, v)
+	case "NUMERIC":
+		var f float64
+		if f, err = strconv.ParseFloat(v, 64); err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return false
+		}
+	default:
+		return false
+	}
+	return err == nil
+}
+
+// normalize — сортировка и размер страницы по умолчанию; неизвестная сортировка — ошибка
+func (f *ListFilter) normalize() error {
+	if f.Sort == "" {
+		f.Sort = "created_at"
+	}
+	if _, ok := sortKeys[f.Sort]; !ok {
+		return fmt.Errorf("%w: unknown sort field %q", ErrInvalidFilter, f.Sort)
+	}
+	if f.Limit <= 0 {
+		f.Limit = defaultListLimit
+	}
+	f.Limit = min(f.Limit, maxListLimit)
+	for _, s := range f.Statuses {
+		if !s.Valid() {
+			return fmt.Errorf("%w %q", appstatus.ErrUnknownStatus, s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package office
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	app := Application{
+		ID:          42,
+		CreatedAt:   time.Date(2026, 3, 1, 12, 30, 45, 123456000, time.UTC),
+		UpdatedAt:   time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC),
+		CargoWeight: 1250.5,
+		CargoVolume: 12,
+	}
+	for sort, key := range sortKeys {
+		for _, asc := range []bool{false, true} {
+			f := ListFilter{Sort: sort, Asc: asc}
+			s := encodeCursor(cursor{Sort: sort, Asc: asc, Value: key.value(app), ID: app.ID})
+			c, err := decodeCursor(s, f)
+			if err != nil {
+				t.Fatalf("%s asc=%v: %v", sort, asc, err)
+			}
+			if c.Value != key.value(app) || c.ID != app.ID {
+				t.Fatalf("%s asc=%v: got %+v", sort, asc, c)
+			}
+		}
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	f := ListFilter{Sort: "created_at"}
+	good := cursor{Sort: "created_at", Value: "2026-03-01 12:30:45.123456", ID: 1}
+	tests := map[string]string{
+		"not base64":    "%%%",
+		"not json":      base64.RawURLEncoding.EncodeToString([]byte("{")),
+		"other sort":    encodeCursor(cursor{Sort: "id", Value: "1", ID: 1}),
+		"other order":   encodeCursor(cursor{Sort: "created_at", Asc: true, Value: good.Value, ID: 1}),
+		"bad timestamp": encodeCursor(cursor{Sort: "created_at", Value: "yesterday", ID: 1}),
+		"sql in value":  encodeCursor(cursor{Sort: "created_at", Value: "2026-03-01'; --", ID: 1}),
+		"empty value":   encodeCursor(cursor{Sort: "created_at", ID: 1}),
+		"unknown sort":  encodeCursor(cursor{Sort: "password", Value: "x", ID: 1}),
+	}
+	for name, s := range tests {
+		ff := f
+		if name == "unknown sort" {
+			ff.Sort = "password"
+		}
+		if _, err := decodeCursor(s, ff); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: got %v, want ErrInvalidCursor", name, err)
+		}
+	}
+	if _, err := decodeCursor(encodeCursor(good), f); err != nil {
+		t.Fatalf("valid cursor rejected: %v", err)
+	}
+}
+
+func TestValidCursorValue(t *testing.T) {
+	tests := []struct {
+		cast, v string
+		ok      bool
+	}{
+		{"BIGINT", "17", true},
+		{"BIGINT", "1.5", false},
+		{"BIGINT", "99999999999999999999", false},
+		{"NUMERIC", "1250.5", true},
+		{"NUMERIC", "-3", true},
+		{"NUMERIC", "NaN", false},
+		{"NUMERIC", "Inf", false},
+		{"NUMERIC", "1e", false},
+		{"TIMESTAMP", "2026-03-01 12:30:45", true},
+		{"TIMESTAMP", "2026-03-01 12:30:45.123456", true},
+		{"TIMESTAMP", "2026-03-01T12:30:45Z", false},
+		{"TEXT", "x", false},
+	}
+	for _, tt := range tests {
+		if got := validCursorValue(tt.cast, tt.v); got != tt.ok {
+			t.Errorf("%s %q: got %v, want %v", tt.cast, tt.v, got, tt.ok)
+		}
+	}
+}
+
+func TestTimeParamUTC(t *testing.T) {
+	q := map[string][]string{"from": {"2026-03-01T15:00:00+03:00"}, "to": {"2026-03-01"}}
+	from, err := timeParam(q, "from", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC); !from.Equal(want) || from.Location() != time.UTC {
+		t.Errorf("from = %v, want %v in UTC", from, want)
+	}
+	to, err := timeParam(q, "to", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC); !to.Equal(want) {
+		t.Errorf("to = %v, want %v (whole day, exclusive)", to, want)
+	}
+	if _, err := timeParam(map[string][]string{"x": {"soon"}}, "x", false); err == nil {
+		t.Error("bad time accepted")
+	}
+}
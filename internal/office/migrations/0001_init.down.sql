@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS applications;
+DROP TYPE IF EXISTS application_status;
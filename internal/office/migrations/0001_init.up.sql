@@ -0,0 +1,46 @@
+-- базовая схема office (то, что раньше создавал EnsureSchema)
+DO $$ BEGIN
+  CREATE TYPE application_status AS ENUM ('NEW','IN_PROGRESS','SHIPPED','DELIVERED','CANCELLED');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE TABLE IF NOT EXISTS applications (
+  id BIGSERIAL PRIMARY KEY,
+  status application_status NOT NULL DEFAULT 'NEW',
+  logistics_point_id BIGINT NOT NULL,
+
+  sender_org_name TEXT NOT NULL,
+  sender_inn TEXT NOT NULL,
+  sender_contact_fio TEXT NOT NULL,
+  sender_contact_phone TEXT NOT NULL,
+  sender_email TEXT,
+
+  cargo_name TEXT NOT NULL,
+  cargo_count INTEGER NOT NULL CHECK (cargo_count > 0),
+  cargo_weight NUMERIC(10,2) NOT NULL CHECK (cargo_weight > 0),
+  cargo_volume NUMERIC(10,2) NOT NULL CHECK (cargo_volume > 0),
+  special_requirements TEXT,
+
+  recipient_org_name TEXT NOT NULL,
+  recipient_address TEXT NOT NULL,
+  recipient_contact_fio TEXT NOT NULL,
+  recipient_contact_phone TEXT NOT NULL,
+
+  -- у заявок от ключей интеграций менеджера нет, есть организация и ключ
+  organization_id BIGINT,
+  created_by_manager_id BIGINT,
+  created_by_api_key_id BIGINT,
+  updated_by_manager_id BIGINT,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+-- базы, созданные до миграций
+ALTER TABLE applications ALTER COLUMN created_by_manager_id DROP DEFAULT;
+ALTER TABLE applications ALTER COLUMN created_by_manager_id DROP NOT NULL;
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS updated_by_manager_id BIGINT;
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS organization_id BIGINT;
+ALTER TABLE applications ADD COLUMN IF NOT EXISTS created_by_api_key_id BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_applications_status ON applications(status);
+CREATE INDEX IF NOT EXISTS idx_applications_point ON applications(logistics_point_id);
+CREATE INDEX IF NOT EXISTS idx_applications_org ON applications(organization_id) WHERE organization_id IS NOT NULL;
@@ -0,0 +1,14 @@
+-- история статусов заявки: пишется в одной транзакции со сменой статуса;
+-- from_status NULL — создание заявки
+CREATE TABLE application_status_history (
+  id BIGSERIAL PRIMARY KEY,
+  application_id BIGINT NOT NULL REFERENCES applications(id) ON DELETE CASCADE,
+  from_status application_status,
+  to_status application_status NOT NULL,
+  actor_manager_id BIGINT,
+  actor_api_key_id BIGINT,
+  source TEXT NOT NULL,                -- office или id сервиса (logistic)
+  reason TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_app_status_history_app ON application_status_history(application_id, id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_applications_manager;
+DROP INDEX IF EXISTS idx_applications_sender_inn;
+DROP INDEX IF EXISTS idx_applications_updated;
+DROP INDEX IF EXISTS idx_applications_created;
@@ -0,0 +1,5 @@
+-- keyset-пагинация списка: (колонка сортировки, id); фильтры по ИНН и создателю
+CREATE INDEX IF NOT EXISTS idx_applications_created ON applications(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_applications_updated ON applications(updated_at, id);
+CREATE INDEX IF NOT EXISTS idx_applications_sender_inn ON applications(sender_inn);
+CREATE INDEX IF NOT EXISTS idx_applications_manager ON applications(created_by_manager_id) WHERE created_by_manager_id IS NOT NULL;
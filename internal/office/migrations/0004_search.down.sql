@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_applications_search_digits;
+DROP INDEX IF EXISTS idx_applications_search;
+ALTER TABLE applications DROP COLUMN IF EXISTS search_digits;
+ALTER TABLE applications DROP COLUMN IF EXISTS search_tsv;
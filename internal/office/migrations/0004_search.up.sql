@@ -0,0 +1,19 @@
+-- полнотекстовый поиск: названия, ФИО, адрес и груз со стеммингом russian;
+-- ИНН и телефоны — только цифры (по полю через пробел) с триграммным индексом
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE applications ADD COLUMN search_tsv tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('russian', sender_org_name || ' ' || recipient_org_name), 'A') ||
+  setweight(to_tsvector('russian', cargo_name), 'B') ||
+  setweight(to_tsvector('russian', sender_contact_fio || ' ' || recipient_contact_fio), 'B') ||
+  setweight(to_tsvector('russian', recipient_address), 'C')
+) STORED;
+
+ALTER TABLE applications ADD COLUMN search_digits TEXT GENERATED ALWAYS AS (
+  regexp_replace(sender_inn, '\D', '', 'g') || ' ' ||
+  regexp_replace(sender_contact_phone, '\D', '', 'g') || ' ' ||
+  regexp_replace(recipient_contact_phone, '\D', '', 'g')
+) STORED;
+
+CREATE INDEX idx_applications_search ON applications USING GIN (search_tsv);
+CREATE INDEX idx_applications_search_digits ON applications USING GIN (search_digits gin_trgm_ops);
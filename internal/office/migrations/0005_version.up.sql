@@ -0,0 +1,2 @@
+-- версия строки для ETag/If-Match: растёт при каждом изменении заявки
+ALTER TABLE applications ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,12 @@
+-- ключи Idempotency-Key для POST /office/applications: хэш запроса и исходный ответ;
+-- owner — кто создавал (manager:<id> или api_key:<id>), ключи разных авторов не пересекаются
+CREATE TABLE idempotency_keys (
+  owner TEXT NOT NULL,
+  key TEXT NOT NULL,
+  request_hash TEXT NOT NULL,
+  application_id BIGINT REFERENCES applications(id) ON DELETE CASCADE,
+  response JSONB,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (owner, key)
+);
+CREATE INDEX idx_idempotency_keys_created ON idempotency_keys(created_at);
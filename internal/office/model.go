@@ -1,15 +1,20 @@
 package office
 
-import "time"
+import (
+	"time"
 
-type ApplicationStatus string
+	"template/internal/appstatus"
+)
+
+// ApplicationStatus — статус заявки; переходы общие с logistic, см. appstatus
+type ApplicationStatus = appstatus.Status
 
 const (
-	StatusNew        ApplicationStatus = "NEW"
-	StatusInProgress ApplicationStatus = "IN_PROGRESS"
-	StatusShipped    ApplicationStatus = "SHIPPED"
-	StatusDelivered  ApplicationStatus = "DELIVERED"
-	StatusCancelled  ApplicationStatus = "CANCELLED"
+	StatusNew        = appstatus.New
+	StatusInProgress = appstatus.InProgress
+	StatusShipped    = appstatus.Shipped
+	StatusDelivered  = appstatus.Delivered
+	StatusCancelled  = appstatus.Cancelled
 )
 
 type Application struct {
@@ -34,9 +39,15 @@ type Application struct {
 	RecipientContactFIO   string `json:"recipient_contact_fio"`
 	RecipientContactPhone string `json:"recipient_contact_phone"`
 
-	CreatedByManagerID int64     `json:"created_by_manager_id"`
+	// OrganizationID, CreatedByAPIKeyID — для заявок, созданных ключом интеграции
+	OrganizationID     *int64    `json:"organization_id,omitempty"`
+	CreatedByManagerID *int64    `json:"created_by_manager_id,omitempty"`
+	CreatedByAPIKeyID  *int64    `json:"created_by_api_key_id,omitempty"`
+	UpdatedByManagerID *int64    `json:"updated_by_manager_id,omitempty"`
 	CreatedAt          time.Time `json:"created_at"`
 	UpdatedAt          time.Time `json:"updated_at"`
+	// Version — растёт при каждом изменении; ETag заявки
+	Version int64 `json:"version"`
 }
 
 type CreateApplicationRequest struct {
@@ -57,6 +68,24 @@ type CreateApplicationRequest struct {
 	RecipientContactPhone string  `json:"recipient_contact_phone"`
 }
 
+// StatusChange — запись истории статусов заявки
+type StatusChange struct {
+	ID            int64              `json:"id"`
+	ApplicationID int64              `json:"application_id"`
+	FromStatus    *ApplicationStatus `json:"from_status"` // nil — создание заявки
+	ToStatus      ApplicationStatus  `json:"to_status"`
+	// кто менял: менеджер, ключ интеграции или сервис (только Source)
+	ActorManagerID *int64    `json:"actor_manager_id,omitempty"`
+	ActorAPIKeyID  *int64    `json:"actor_api_key_id,omitempty"`
+	Source         string    `json:"source"` // office или id сервиса (logistic)
+	Reason         *string   `json:"reason,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
 type UpdateStatusRequest struct {
 	Status ApplicationStatus `json:"status"`
+	Reason string            `json:"reason,omitempty"`
+	// ActorManagerID — менеджер, сменивший статус в вызывающем сервисе;
+	// учитывается только от сервисов (внутренний API)
+	ActorManagerID *int64 `json:"actor_manager_id,omitempty"`
 }
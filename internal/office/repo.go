@@ -3,139 +3,419 @@ package office
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"template/internal/appstatus"
+	"template/internal/db"
 )
 
+var ErrNotFound = errors.New("not found")
+
+// Author — кто создаёт заявку: менеджер или ключ интеграции организации
+type Author struct {
+	ManagerID      *int64
+	APIKeyID       *int64
+	OrganizationID *int64
+}
+
+// orgID во всех выборках — область видимости ключа интеграции; nil — без ограничения
 type Repo interface {
-	EnsureSchema(ctx context.Context) error
-	Insert(ctx context.Context, r CreateApplicationRequest) (Application, error)
-	GetByID(ctx context.Context, id int64) (Application, error)
-	UpdateStatus(ctx context.Context, id int64, status ApplicationStatus) error
-	List(ctx context.Context, status *string) ([]Application, error)
+	Insert(ctx context.Context, r CreateApplicationRequest, by Author) (Application, error)
+	// InsertIdempotent — Insert под ключом ik: повтор с тем же ключом и телом отдаёт
+	// сохранённую заявку (replayed), с другим телом — ErrIdempotencyKeyReused.
+	// Ключи старше since считаются свободными
+	InsertIdempotent(ctx context.Context, r CreateApplicationRequest, by Author, ik Idempotency, since time.Time) (app Application, replayed bool, err error)
+	PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+	GetByID(ctx context.Context, id int64, orgID *int64) (Application, error)
+	// UpdateStatus меняет статус на ch.ToStatus, только если он всё ещё from
+	// (иначе appstatus.ErrConflict), и в той же транзакции пишет ch в историю
+	UpdateStatus(ctx context.Context, id int64, from ApplicationStatus, ch StatusChange, orgID *int64) error
+	History(ctx context.Context, id int64) ([]StatusChange, error)
+	// Update применяет патч, только если версия заявки всё ещё version (иначе ErrVersionMismatch)
+	Update(ctx context.Context, id, version int64, p ApplicationPatch, updatedBy, orgID *int64) (Application, error)
+	Search(ctx context.Context, sq searchQuery, orgID *int64) ([]SearchResult, error)
+	List(ctx context.Context, f ListFilter, orgID *int64) (ApplicationPage, error)
 }
 
 type pgRepo struct{ db *sql.DB }
 
 func NewRepo(db *sql.DB) Repo { return &pgRepo{db: db} }
 
-func (r *pgRepo) EnsureSchema(ctx context.Context) error {
-	ddl := `
-CREATE TYPE IF NOT EXISTS application_status AS ENUM ('NEW','IN_PROGRESS','SHIPPED','DELIVERED','CANCELLED');
-
-CREATE TABLE IF NOT EXISTS applications (
-  id BIGSERIAL PRIMARY KEY,
-  status application_status NOT NULL DEFAULT 'NEW',
-  logistics_point_id BIGINT NOT NULL,
-
-  sender_org_name TEXT NOT NULL,
-  sender_inn TEXT NOT NULL,
-  sender_contact_fio TEXT NOT NULL,
-  sender_contact_phone TEXT NOT NULL,
-  sender_email TEXT,
-
-  cargo_name TEXT NOT NULL,
-  cargo_count INTEGER NOT NULL CHECK (cargo_count > 0),
-  cargo_weight NUMERIC(10,2) NOT NULL CHECK (cargo_weight > 0),
-  cargo_volume NUMERIC(10,2) NOT NULL CHECK (cargo_volume > 0),
-  special_requirements TEXT,
-
-  recipient_org_name TEXT NOT NULL,
-  recipient_address TEXT NOT NULL,
-  recipient_contact_fio TEXT NOT NULL,
-  recipient_contact_phone TEXT NOT NULL,
-
-  created_by_manager_id BIGINT NOT NULL DEFAULT 1,
-  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
-);
-CREATE INDEX IF NOT EXISTS idx_applications_status ON applications(status);
-CREATE INDEX IF NOT EXISTS idx_applications_point ON applications(logistics_point_id);
-`
-	_, err := r.db.ExecContext(ctx, ddl)
-	return err
-}
-
-func (r *pgRepo) Insert(ctx context.Context, req CreateApplicationRequest) (Application, error) {
-	row := r.db.QueryRowContext(ctx, `
+const appColumns = `id,status,logistics_point_id,
+       sender_org_name,sender_inn,sender_contact_fio,sender_contact_phone,sender_email,
+       cargo_name,cargo_count,cargo_weight,cargo_volume,special_requirements,
+       recipient_org_name,recipient_address,recipient_contact_fio,recipient_contact_phone,
+       organization_id,created_by_manager_id,created_by_api_key_id,updated_by_manager_id,created_at,updated_at,version`
+
+type rowScanner interface{ Scan(dest ...any) error }
+
+func scanApp(row rowScanner) (Application, error) {
+	var app Application
+	err := row.Scan(&app.ID, &app.Status, &app.LogisticsPointID,
+		&app.SenderOrgName, &app.SenderINN, &app.SenderContactFIO, &app.SenderContactPhone, &app.SenderEmail,
+		&app.CargoName, &app.CargoCount, &app.CargoWeight, &app.CargoVolume, &app.SpecialRequirements,
+		&app.RecipientOrgName, &app.RecipientAddress, &app.RecipientContactFIO, &app.RecipientContactPhone,
+		&app.OrganizationID, &app.CreatedByManagerID, &app.CreatedByAPIKeyID, &app.UpdatedByManagerID, &app.CreatedAt, &app.UpdatedAt, &app.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Application{}, ErrNotFound
+	}
+	return app, err
+}
+
+// sourceOffice — источник записей истории, сделанных самим office
+const sourceOffice = "office"
+
+// queryRower — *sql.DB или *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (r *pgRepo) Insert(ctx context.Context, req CreateApplicationRequest, by Author) (Application, error) {
+	return insertApp(ctx, r.db, req, by)
+}
+
+func insertApp(ctx context.Context, q queryRower, req CreateApplicationRequest, by Author) (Application, error) {
+	row := q.QueryRowContext(ctx, `
+WITH app AS (
 INSERT INTO applications (
   status, logistics_point_id,
   sender_org_name, sender_inn, sender_contact_fio, sender_contact_phone, sender_email,
   cargo_name, cargo_count, cargo_weight, cargo_volume, special_requirements,
-  recipient_org_name, recipient_address, recipient_contact_fio, recipient_contact_phone
-) VALUES ('NEW',$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)
-RETURNING id,status,logistics_point_id,sender_org_name,sender_inn,sender_contact_fio,sender_contact_phone,sender_email,
-          cargo_name,cargo_count,cargo_weight,cargo_volume,special_requirements,
-          recipient_org_name,recipient_address,recipient_contact_fio,recipient_contact_phone,
-          created_by_manager_id,created_at,updated_at
-`,
+  recipient_org_name, recipient_address, recipient_contact_fio, recipient_contact_phone,
+  created_by_manager_id, created_by_api_key_id, organization_id
+) VALUES ('NEW',$1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18)
+RETURNING `+appColumns+`
+), hist AS (
+INSERT INTO application_status_history (application_id, to_status, actor_manager_id, actor_api_key_id, source)
+SELECT id, status, created_by_manager_id, created_by_api_key_id, $19 FROM app
+)
+SELECT `+appColumns+` FROM app`,
 		req.LogisticsPointID,
 		req.SenderOrgName, req.SenderINN, req.SenderContactFIO, req.SenderContactPhone, req.SenderEmail,
 		req.CargoName, req.CargoCount, req.CargoWeight, req.CargoVolume, req.SpecialRequirements,
 		req.RecipientOrgName, req.RecipientAddress, req.RecipientContactFIO, req.RecipientContactPhone,
+		by.ManagerID, by.APIKeyID, by.OrganizationID, sourceOffice,
 	)
+	return scanApp(row)
+}
 
-	var app Application
-	err := row.Scan(
-		&app.ID, &app.Status, &app.LogisticsPointID,
-		&app.SenderOrgName, &app.SenderINN, &app.SenderContactFIO, &app.SenderContactPhone, &app.SenderEmail,
-		&app.CargoName, &app.CargoCount, &app.CargoWeight, &app.CargoVolume, &app.SpecialRequirements,
-		&app.RecipientOrgName, &app.RecipientAddress, &app.RecipientContactFIO, &app.RecipientContactPhone,
-		&app.CreatedByManagerID, &app.CreatedAt, &app.UpdatedAt,
-	)
-	return app, err
+// InsertIdempotent — ключ занимается первой вставкой в транзакции: параллельный
+// запрос с тем же ключом ждёт её завершения и затем видит готовый ответ
+func (r *pgRepo) InsertIdempotent(ctx context.Context, req CreateApplicationRequest, by Author, ik Idempotency, since time.Time) (Application, bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Application{}, false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO idempotency_keys (owner, key, request_hash) VALUES ($1,$2,$3)
+ON CONFLICT (owner, key) DO UPDATE
+SET request_hash=EXCLUDED.request_hash, application_id=NULL, response=NULL, created_at=NOW()
+WHERE idempotency_keys.created_at < $4`, ik.Owner, ik.Key, ik.RequestHash, since)
+	if err != nil {
+		return Application{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var hash string
+		var resp []byte
+		if err := tx.QueryRowContext(ctx, `SELECT request_hash, response FROM idempotency_keys WHERE owner=$1 AND key=$2`,
+			ik.Owner, ik.Key).Scan(&hash, &resp); err != nil {
+			return Application{}, false, err
+		}
+		if hash != ik.RequestHash {
+			return Application{}, false, ErrIdempotencyKeyReused
+		}
+		var app Application
+		err := json.Unmarshal(resp, &app)
+		return app, true, err
+	}
+	app, err := insertApp(ctx, tx, req, by)
+	if err != nil {
+		return Application{}, false, err
+	}
+	resp, err := json.Marshal(app)
+	if err != nil {
+		return Application{}, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE idempotency_keys SET application_id=$3, response=$4 WHERE owner=$1 AND key=$2`,
+		ik.Owner, ik.Key, app.ID, resp); err != nil {
+		return Application{}, false, err
+	}
+	return app, false, tx.Commit()
 }
 
-func (r *pgRepo) GetByID(ctx context.Context, id int64) (Application, error) {
-	row := r.db.QueryRowContext(ctx, `
-SELECT id,status,logistics_point_id,
-       sender_org_name,sender_inn,sender_contact_fio,sender_contact_phone,sender_email,
-       cargo_name,cargo_count,cargo_weight,cargo_volume,special_requirements,
-       recipient_org_name,recipient_address,recipient_contact_fio,recipient_contact_phone,
-       created_by_manager_id,created_at,updated_at
-FROM applications WHERE id=$1`, id)
+func (r *pgRepo) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
 
-	var app Application
-	err := row.Scan(&app.ID, &app.Status, &app.LogisticsPointID,
-		&app.SenderOrgName, &app.SenderINN, &app.SenderContactFIO, &app.SenderContactPhone, &app.SenderEmail,
-		&app.CargoName, &app.CargoCount, &app.CargoWeight, &app.CargoVolume, &app.SpecialRequirements,
-		&app.RecipientOrgName, &app.RecipientAddress, &app.RecipientContactFIO, &app.RecipientContactPhone,
-		&app.CreatedByManagerID, &app.CreatedAt, &app.UpdatedAt)
+func (r *pgRepo) GetByID(ctx context.Context, id int64, orgID *int64) (Application, error) {
+	return scanApp(r.db.QueryRowContext(ctx, `SELECT `+appColumns+`
+FROM applications WHERE id=$1 AND ($2::BIGINT IS NULL OR organization_id=$2)`, id, orgID))
+}
+
+func (r *pgRepo) UpdateStatus(ctx context.Context, id int64, from ApplicationStatus, ch StatusChange, orgID *int64) error {
+	// один оператор — смена статуса и запись истории атомарны
+	res, err := r.db.ExecContext(ctx, `
+WITH upd AS (
+  UPDATE applications SET status=$1, updated_by_manager_id=$2, updated_at=NOW(), version=version+1
+  WHERE id=$3 AND status=$4 AND ($5::BIGINT IS NULL OR organization_id=$5)
+  RETURNING id
+)
+INSERT INTO application_status_history (application_id, from_status, to_status, actor_manager_id, actor_api_key_id, source, reason)
+SELECT id, $4, $1, $2, $6, $7, $8 FROM upd`,
+		ch.ToStatus, ch.ActorManagerID, id, from, orgID, ch.ActorAPIKeyID, ch.Source, ch.Reason)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return appstatus.ErrConflict
+	}
+	return nil
+}
+
+// List — страница заявок по фильтру (f уже нормализован сервисом): keyset-
+// пагинация по (колонка сортировки, id) и общее число подходящих заявок
+func (r *pgRepo) List(ctx context.Context, f ListFilter, orgID *int64) (ApplicationPage, error) {
+	key := sortKeys[f.Sort]
+	q := listQuery(f, orgID)
+
+	page := ApplicationPage{Items: []Application{}}
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM applications`+q.WhereSQL(), q.Args()...).Scan(&page.Total); err != nil {
+		return ApplicationPage{}, err
+	}
+
+	cmp, dir := "<", "DESC"
+	if f.Asc {
+		cmp, dir = ">", "ASC"
+	}
+	pageQ := q.Clone()
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor, f)
+		if err != nil {
+			return ApplicationPage{}, err
+		}
+		pageQ.Where("("+key.column+", id) "+cmp+" (?::"+key.cast+", ?)", c.Value, c.ID)
+	}
+	// на одну больше — узнать, есть ли следующая страница
+	rows, err := r.db.QueryContext(ctx, `SELECT `+appColumns+` FROM applications`+pageQ.WhereSQL()+
+		` ORDER BY `+key.column+` `+dir+`, id `+dir+` LIMIT `+pageQ.Arg(f.Limit+1), pageQ.Args()...)
+	if err != nil {
+		return ApplicationPage{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		app, err := scanApp(rows)
+		if err != nil {
+			return ApplicationPage{}, err
+		}
+		page.Items = append(page.Items, app)
+	}
+	if err := rows.Err(); err != nil {
+		return ApplicationPage{}, err
+	}
+	if len(page.Items) > f.Limit {
+		page.Items = page.Items[:f.Limit]
+		last := page.Items[f.Limit-1]
+		page.NextCursor = encodeCursor(cursor{Sort: f.Sort, Asc: f.Asc, Value: key.value(last), ID: last.ID})
+	}
+	return page, nil
+}
+
+// listQuery — условия фильтра; названия колонок только из кода, значения — параметрами
+func listQuery(f ListFilter, orgID *int64) *db.Query {
+	q := &db.Query{}
+	if orgID != nil {
+		q.Where("organization_id = ?", *orgID)
+	}
+	if len(f.Statuses) > 0 {
+		statuses := make([]string, len(f.Statuses))
+		for i, s := range f.Statuses {
+			statuses[i] = string(s)
+		}
+		q.Where("status = ANY(?::application_status[])", pq.Array(statuses))
+	}
+	if f.LogisticsPointID != nil {
+		q.Where("logistics_point_id = ?", *f.LogisticsPointID)
+	}
+	if f.SenderINN != "" {
+		q.Where("sender_inn = ?", f.SenderINN)
+	}
+	if f.RecipientOrg != "" {
+		q.Where("recipient_org_name ILIKE ?", db.Contains(f.RecipientOrg))
+	}
+	if f.ManagerID != nil {
+		q.Where("created_by_manager_id = ?", *f.ManagerID)
+	}
+	if f.CreatedFrom != nil {
+		q.Where("created_at >= ?", *f.CreatedFrom)
+	}
+	if f.CreatedTo != nil {
+		q.Where("created_at < ?", *f.CreatedTo)
+	}
+	if f.UpdatedFrom != nil {
+		q.Where("updated_at >= ?", *f.UpdatedFrom)
+	}
+	if f.UpdatedTo != nil {
+		q.Where("updated_at < ?", *f.UpdatedTo)
+	}
+	if f.WeightMin != nil {
+		q.Where("cargo_weight >= ?", *f.WeightMin)
+	}
+	if f.WeightMax != nil {
+		q.Where("cargo_weight <= ?", *f.WeightMax)
+	}
+	if f.VolumeMin != nil {
+		q.Where("cargo_volume >= ?", *f.VolumeMin)
+	}
+	if f.VolumeMax != nil {
+		q.Where("cargo_volume <= ?", *f.VolumeMax)
+	}
+	return q
+}
+
+func (r *pgRepo) Update(ctx context.Context, id, version int64, p ApplicationPatch, updatedBy, orgID *int64) (Application, error) {
+	q := &db.Query{}
+	sets := []string{"updated_at = NOW()", "version = version + 1", "updated_by_manager_id = " + q.Arg(updatedBy)}
+	set := func(column string, v any) { sets = append(sets, column+" = "+q.Arg(v)) }
+	// "" в необязательных полях — NULL
+	optional := func(column string, v *string) {
+		if *v == "" {
+			set(column, nil)
+		} else {
+			set(column, *v)
+		}
+	}
+	if p.LogisticsPointID != nil {
+		set("logistics_point_id", *p.LogisticsPointID)
+	}
+	if p.SenderOrgName != nil {
+		set("sender_org_name", *p.SenderOrgName)
+	}
+	if p.SenderINN != nil {
+		set("sender_inn", *p.SenderINN)
+	}
+	if p.SenderContactFIO != nil {
+		set("sender_contact_fio", *p.SenderContactFIO)
+	}
+	if p.SenderContactPhone != nil {
+		set("sender_contact_phone", *p.SenderContactPhone)
+	}
+	if p.SenderEmail != nil {
+		optional("sender_email", p.SenderEmail)
+	}
+	if p.CargoName != nil {
+		set("cargo_name", *p.CargoName)
+	}
+	if p.CargoCount != nil {
+		set("cargo_count", *p.CargoCount)
+	}
+	if p.CargoWeight != nil {
+		set("cargo_weight", *p.CargoWeight)
+	}
+	if p.CargoVolume != nil {
+		set("cargo_volume", *p.CargoVolume)
+	}
+	if p.SpecialRequirements != nil {
+		optional("special_requirements", p.SpecialRequirements)
+	}
+	if p.RecipientOrgName != nil {
+		set("recipient_org_name", *p.RecipientOrgName)
+	}
+	if p.RecipientAddress != nil {
+		set("recipient_address", *p.RecipientAddress)
+	}
+	if p.RecipientContactFIO != nil {
+		set("recipient_contact_fio", *p.RecipientContactFIO)
+	}
+	if p.RecipientContactPhone != nil {
+		set("recipient_contact_phone", *p.RecipientContactPhone)
+	}
+	q.Where("id = ?", id)
+	q.Where("version = ?", version)
+	if orgID != nil {
+		q.Where("organization_id = ?", *orgID)
+	}
+	app, err := scanApp(r.db.QueryRowContext(ctx, `UPDATE applications SET `+strings.Join(sets, ", ")+q.WhereSQL()+
+		` RETURNING `+appColumns, q.Args()...))
+	if errors.Is(err, ErrNotFound) {
+		return Application{}, ErrVersionMismatch
+	}
 	return app, err
 }
 
-func (r *pgRepo) UpdateStatus(ctx context.Context, id int64, status ApplicationStatus) error {
-	_, err := r.db.ExecContext(ctx, `UPDATE applications SET status=$1, updated_at=NOW() WHERE id=$2`, status, id)
-	return err
+func (r *pgRepo) History(ctx context.Context, id int64) ([]StatusChange, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id, application_id, from_status, to_status, actor_manager_id, actor_api_key_id, source, reason, created_at
+FROM application_status_history WHERE application_id=$1 ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []StatusChange{}
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.ID, &c.ApplicationID, &c.FromStatus, &c.ToStatus,
+			&c.ActorManagerID, &c.ActorAPIKeyID, &c.Source, &c.Reason, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
 }
 
-func (r *pgRepo) List(ctx context.Context, status *string) ([]Application, error) {
-	q := `SELECT id,status,logistics_point_id,
-       sender_org_name,sender_inn,sender_contact_fio,sender_contact_phone,sender_email,
-       cargo_name,cargo_count,cargo_weight,cargo_volume,special_requirements,
-       recipient_org_name,recipient_address,recipient_contact_fio,recipient_contact_phone,
-       created_by_manager_id,created_at,updated_at
-FROM applications`
-	args := []any{}
-	if status != nil && *status != "" {
-		q += " WHERE status=$1"
-		args = append(args, *status)
-	}
-	q += " ORDER BY id DESC LIMIT 100"
-	rows, err := r.db.QueryContext(ctx, q, args...)
+// Search — полнотекстовый поиск по search_tsv, для чисто цифровых запросов ещё
+// и по фрагменту ИНН или телефона (search_digits, триграммный индекс)
+func (r *pgRepo) Search(ctx context.Context, sq searchQuery, orgID *int64) ([]SearchResult, error) {
+	q := &db.Query{}
+	tsq := "to_tsquery('russian', " + q.Arg(sq.tsQuery) + ")"
+	match := "search_tsv @@ " + tsq
+	rank := "ts_rank(search_tsv, " + tsq + ")"
+	if sq.digits != "" {
+		d := q.Arg(sq.digits)
+		digitsMatch := "search_digits LIKE '%' || " + d + " || '%'"
+		match += " OR " + digitsMatch
+		rank += " + CASE WHEN " + digitsMatch + " THEN word_similarity(" + d + ", search_digits) ELSE 0 END"
+	}
+	q.Where(match)
+	if orgID != nil {
+		q.Where("organization_id = ?", *orgID)
+	}
+	headline := "ts_headline('russian', concat_ws(' · ', sender_org_name, recipient_org_name, cargo_name, " +
+		"sender_contact_fio, recipient_contact_fio, recipient_address), " + tsq + ", " + q.Arg(headlineOptions) + ")"
+	rows, err := r.db.QueryContext(ctx, `SELECT `+appColumns+`, `+rank+` AS rank, `+headline+`
+FROM applications`+q.WhereSQL()+` ORDER BY rank DESC, id DESC LIMIT `+q.Arg(sq.limit), q.Args()...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var list []Application
+	list := []SearchResult{}
 	for rows.Next() {
-		var app Application
-		if err := rows.Scan(&app.ID, &app.Status, &app.LogisticsPointID,
-			&app.SenderOrgName, &app.SenderINN, &app.SenderContactFIO, &app.SenderContactPhone, &app.SenderEmail,
-			&app.CargoName, &app.CargoCount, &app.CargoWeight, &app.CargoVolume, &app.SpecialRequirements,
-			&app.RecipientOrgName, &app.RecipientAddress, &app.RecipientContactFIO, &app.RecipientContactPhone,
-			&app.CreatedByManagerID, &app.CreatedAt, &app.UpdatedAt); err != nil {
+		var res SearchResult
+		var hl string
+		app, err := scanApp(withExtra(rows, &res.Rank, &hl))
+		if err != nil {
 			return nil, err
 		}
-		list = append(list, app)
+		res.Application, res.Highlight = app, highlightHTML(hl)
+		list = append(list, res)
 	}
-	return list, nil
+	return list, rows.Err()
 }
+
+// withExtra — сканирование заявки и дополнительных колонок после appColumns
+func withExtra(row rowScanner, extra ...any) rowScanner {
+	return scanFunc(func(dest ...any) error { return row.Scan(append(dest, extra...)...) })
+}
+
+type scanFunc func(dest ...any) error
+
+func (f scanFunc) Scan(dest ...any) error { return f(dest...) }
@@ -0,0 +1,84 @@
+package office
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	maxSearchLen       = 200
+	// цифровой фрагмент короче не ищется по ИНН и телефонам (триграммы)
+	minDigitsLen = 3
+)
+
+var ErrBadSearch = errors.New("search query must contain letters or digits")
+
+// SearchResult — заявка с релевантностью и фрагментом, где найдены слова
+// запроса: HTML, совпадения в <mark>, остальное экранировано
+type SearchResult struct {
+	Application Application `json:"application"`
+	Rank        float64     `json:"rank"`
+	Highlight   string      `json:"highlight"`
+}
+
+// searchQuery — разобранный запрос: префиксный tsquery по словам и, если букв
+// нет, цифры для поиска по ИНН и телефонам
+type searchQuery struct {
+	tsQuery string
+	digits  string
+	limit   int
+}
+
+// parseSearch — слова из букв и цифр (остальное — разделители, поэтому синтаксис
+// tsquery в запрос не попадает), каждое как префикс: «ромаш» находит «Ромашка»
+func parseSearch(q string, limit int) (searchQuery, error) {
+	if utf8.RuneCountInString(q) > maxSearchLen {
+		return searchQuery{}, ErrBadSearch
+	}
+	words := strings.FieldsFunc(q, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	if len(words) == 0 {
+		return searchQuery{}, ErrBadSearch
+	}
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = strings.ToLower(w) + ":*"
+	}
+	sq := searchQuery{tsQuery: strings.Join(terms, " & "), limit: limit}
+	if !strings.ContainsFunc(q, unicode.IsLetter) {
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, q)
+		if len(digits) >= minDigitsLen {
+			sq.digits = digits
+		}
+	}
+	if sq.limit <= 0 {
+		sq.limit = defaultSearchLimit
+	}
+	sq.limit = min(sq.limit, maxSearchLimit)
+	return sq, nil
+}
+
+// маркеры совпадений в ts_headline — из области частного использования Unicode,
+// чтобы экранировать текст заявки и только потом превратить их в <mark>
+const (
+	markStart = "\uE000"
+	markStop  = "\uE001"
+)
+
+// headlineOptions — для ts_headline; маркеры — те же символы
+const headlineOptions = "StartSel=" + markStart + ", StopSel=" + markStop +
+	", MaxWords=15, MinWords=5, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// highlightHTML — фрагмент ts_headline в безопасный HTML
+func highlightHTML(s string) string {
+	return strings.NewReplacer(markStart, "<mark>", markStop, "</mark>").Replace(html.EscapeString(s))
+}
@@ -1,33 +1,44 @@
 package office
 
 import (
-	"context"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"template/internal/db"
 	"template/internal/middleware/authmw"
+	"template/internal/migrate"
+	"template/internal/svcauth"
 )
 
-func Start(port, dsn, jwtSecret string) {
+func Start(port, dsn, jwksURL, revocationsURL, apiKeysURL, tokenURL, clientID, clientSecret string) {
 	dbc := db.MustConnect(dsn)
+	migrate.MustUp(dbc, "office", Migrations())
 	repo := NewRepo(dbc)
-	if err := repo.EnsureSchema(context.Background()); err != nil {
-		log.Fatalf("office ensure schema: %v", err)
-	}
 	svc, _ := NewService(repo)
+	go purgeIdempotencyKeys(repo, time.Hour)
 
 	r := mux.NewRouter()
 	// подпрефикс /office
 	officeRouter := r.PathPrefix("/office").Subrouter()
 
-	// доступ только менеджеру офиса
-	mw := authmw.New(jwtSecret)
-	officeRouter.Use(mw.RequireRoles("office_manager"))
-
-	NewHandler(svc).Register(officeRouter)
+	// доступ — по разрешениям в токене, см. Handler.Register
+	// кроме токенов — ключи интеграций в X-API-Key; их проверка и список отзыва —
+	// через внутренний API auth с токеном сервиса office
+	authClient := svcauth.NewTokenSource(tokenURL, clientID, clientSecret).Client()
+	mw := authmw.New(authmw.NewRemoteJWKS(jwksURL, 5*time.Minute), authmw.NewRemoteRevocations(revocationsURL, authClient, 15*time.Second)).
+		WithAPIKeys(authmw.NewRemoteAPIKeys(apiKeysURL, authClient))
+
+	h := NewHandler(svc, mw)
+	h.Register(officeRouter)
+
+	// внутренние вызовы: только сервис logistic со своим токеном;
+	// прокси этот префикс наружу не пробрасывает
+	internalRouter := r.PathPrefix("/internal/office").Subrouter()
+	internalRouter.Use(mw.RequireService("logistic"))
+	h.RegisterInternal(internalRouter)
 
 	log.Printf("[office] :%s (dsn=%s)", port, dsn)
 	log.Fatal(http.ListenAndServe(":"+port, r))
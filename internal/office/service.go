@@ -3,13 +3,24 @@ package office
 import (
 	"context"
 	"errors"
+	"time"
+
+	"template/internal/appstatus"
+	"template/internal/middleware/authmw"
 )
 
 type Service interface {
 	Create(ctx context.Context, req CreateApplicationRequest) (Application, error)
+	// CreateIdempotent — Create с заголовком Idempotency-Key (пустой — как Create);
+	// replayed — заявка уже была создана этим ключом, ответ повторяется
+	CreateIdempotent(ctx context.Context, req CreateApplicationRequest, key string) (app Application, replayed bool, err error)
 	Get(ctx context.Context, id int64) (Application, error)
-	UpdateStatus(ctx context.Context, id int64, status ApplicationStatus) error
-	List(ctx context.Context, status *string) ([]Application, error)
+	// Update — правка полей заявки; version — версия, которую видел клиент (If-Match)
+	Update(ctx context.Context, id, version int64, p ApplicationPatch) (Application, error)
+	UpdateStatus(ctx context.Context, id int64, req UpdateStatusRequest) error
+	History(ctx context.Context, id int64) ([]StatusChange, error)
+	Search(ctx context.Context, q string, limit int) ([]SearchResult, error)
+	List(ctx context.Context, f ListFilter) (ApplicationPage, error)
 }
 
 type service struct{ repo Repo }
@@ -22,25 +33,145 @@ func NewService(repo Repo) (Service, error) {
 }
 
 func (s *service) Create(ctx context.Context, req CreateApplicationRequest) (Application, error) {
+	app, _, err := s.CreateIdempotent(ctx, req, "")
+	return app, err
+}
+
+func (s *service) CreateIdempotent(ctx context.Context, req CreateApplicationRequest, key string) (Application, bool, error) {
 	// базовая валидация (по ТЗ — все обязательные поля) :contentReference[oaicite:1]{index=1}
 	if req.LogisticsPointID == 0 ||
 		req.SenderOrgName == "" || req.SenderINN == "" ||
 		req.SenderContactFIO == "" || req.SenderContactPhone == "" ||
 		req.CargoName == "" || req.CargoCount <= 0 || req.CargoWeight <= 0 || req.CargoVolume <= 0 ||
 		req.RecipientOrgName == "" || req.RecipientAddress == "" || req.RecipientContactFIO == "" || req.RecipientContactPhone == "" {
-		return Application{}, errors.New("missing required fields")
+		return Application{}, false, errors.New("missing required fields")
+	}
+	by, ok := author(ctx)
+	if !ok {
+		return Application{}, false, errors.New("manager or api key required")
+	}
+	ik, err := idempotency(key, by, req)
+	if err != nil {
+		return Application{}, false, err
 	}
-	return s.repo.Insert(ctx, req)
+	if ik == nil {
+		app, err := s.repo.Insert(ctx, req, by)
+		return app, false, err
+	}
+	return s.repo.InsertIdempotent(ctx, req, by, *ik, time.Now().Add(-IdempotencyRetention))
 }
 
 func (s *service) Get(ctx context.Context, id int64) (Application, error) {
-	return s.repo.GetByID(ctx, id)
+	return s.repo.GetByID(ctx, id, orgScope(ctx))
+}
+
+// Update — проверка полей, правила статуса (editableIn) и версии; сама запись —
+// тоже только при той же версии, так что смена статуса между чтением и записью
+// не пропустит правку замороженных полей
+func (s *service) Update(ctx context.Context, id, version int64, p ApplicationPatch) (Application, error) {
+	if err := p.normalize(); err != nil {
+		return Application{}, err
+	}
+	app, err := s.repo.GetByID(ctx, id, orgScope(ctx))
+	if err != nil {
+		return Application{}, err
+	}
+	if app.Version != version {
+		return Application{}, ErrVersionMismatch
+	}
+	if err := p.checkEditable(app.Status); err != nil {
+		return Application{}, err
+	}
+	caller, _ := authmw.FromContext(ctx)
+	return s.repo.Update(ctx, id, version, p, caller.ManagerID(), orgScope(ctx))
+}
+
+// UpdateStatus — только разрешённые переходы (appstatus.Check); тот же статус — без
+// изменений. Каждая смена попадает в историю вместе с reason
+func (s *service) UpdateStatus(ctx context.Context, id int64, req UpdateStatusRequest) error {
+	status := req.Status
+	why, err := appstatus.Reason(req.Reason)
+	if err != nil {
+		return err
+	}
+	app, err := s.repo.GetByID(ctx, id, orgScope(ctx))
+	if err != nil {
+		return err
+	}
+	if err := appstatus.Check(app.Status, status); err != nil || app.Status == status {
+		return err
+	}
+	ch := statusChange(ctx, status)
+	ch.Reason = why
+	if p, _ := authmw.FromContext(ctx); p.IsService() {
+		ch.ActorManagerID = req.ActorManagerID
+	}
+	return s.repo.UpdateStatus(ctx, id, app.Status, ch, orgScope(ctx))
 }
 
-func (s *service) UpdateStatus(ctx context.Context, id int64, status ApplicationStatus) error {
-	return s.repo.UpdateStatus(ctx, id, status)
+// History — смены статуса заявки по порядку, начиная с создания
+func (s *service) History(ctx context.Context, id int64) ([]StatusChange, error) {
+	if _, err := s.repo.GetByID(ctx, id, orgScope(ctx)); err != nil {
+		return nil, err
+	}
+	return s.repo.History(ctx, id)
+}
+
+func (s *service) List(ctx context.Context, f ListFilter) (ApplicationPage, error) {
+	if err := f.normalize(); err != nil {
+		return ApplicationPage{}, err
+	}
+	return s.repo.List(ctx, f, orgScope(ctx))
 }
 
-func (s *service) List(ctx context.Context, status *string) ([]Application, error) {
-	return s.repo.List(ctx, status)
+// author — создатель заявки: менеджер или ключ интеграции (вместе с его организацией)
+func author(ctx context.Context) (Author, bool) {
+	p, _ := authmw.FromContext(ctx)
+	if p.IsAPIKey() {
+		if p.OrganizationID == nil {
+			return Author{}, false
+		}
+		key := p.APIKeyID
+		return Author{APIKeyID: &key, OrganizationID: p.OrganizationID}, true
+	}
+	id := p.ManagerID()
+	return Author{ManagerID: id}, id != nil
+}
+
+// Search — заявки по словам запроса (префиксы, со стеммингом) или по цифрам ИНН и телефонов
+func (s *service) Search(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	sq, err := parseSearch(q, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.Search(ctx, sq, orgScope(ctx))
+}
+
+// statusChange — запись истории от имени текущего вызывающего: менеджер,
+// ключ интеграции или сервис (тогда источник — id сервиса, а менеджер — из
+// запроса сервиса, см. UpdateStatus)
+func statusChange(ctx context.Context, to ApplicationStatus) StatusChange {
+	p, _ := authmw.FromContext(ctx)
+	ch := StatusChange{ToStatus: to, ActorManagerID: p.ManagerID(), Source: sourceOffice}
+	if p.IsAPIKey() {
+		key := p.APIKeyID
+		ch.ActorAPIKeyID = &key
+	}
+	if p.IsService() {
+		ch.Source = p.Username
+	}
+	return ch
+}
+
+// orgScope — ключ интеграции видит только заявки своей организации
+func orgScope(ctx context.Context) *int64 {
+	p, _ := authmw.FromContext(ctx)
+	if !p.IsAPIKey() {
+		return nil
+	}
+	if p.OrganizationID == nil {
+		none := int64(-1)
+		return &none
+	}
+	return p.OrganizationID
 }
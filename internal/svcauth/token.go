@@ -0,0 +1,94 @@
+package svcauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source — откуда берётся токен сервиса
+type Source interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSource — токен сервиса по OAuth 2.0 client credentials из auth.
+// Токен кешируется и обновляется заранее, до истечения.
+type TokenSource struct {
+	tokenURL string
+	clientID string
+	secret   string
+	client   *http.Client
+
+	mu    sync.Mutex
+	token string
+	exp   time.Time
+}
+
+func NewTokenSource(tokenURL, clientID, secret string) *TokenSource {
+	return &TokenSource{
+		tokenURL: tokenURL,
+		clientID: clientID,
+		secret:   secret,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.exp) > 30*time.Second {
+		return s.token, nil
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.clientID, s.secret)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	s.token = out.AccessToken
+	s.exp = time.Now().Add(time.Duration(out.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+// Client — http.Client, подставляющий токен сервиса в каждый запрос
+func (s *TokenSource) Client() *http.Client { return NewClient(s) }
+
+func NewClient(src Source) *http.Client {
+	return &http.Client{Timeout: 10 * time.Second, Transport: &transport{src: src, base: http.DefaultTransport}}
+}
+
+type transport struct {
+	src  Source
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	tok, err := t.src.Token(r.Context())
+	if err != nil {
+		return nil, fmt.Errorf("service token: %w", err)
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+tok)
+	return t.base.RoundTrip(r)
+}